@@ -0,0 +1,37 @@
+package newmath
+
+import "math"
+
+// cbrtMaxIter bounds the number of Newton steps Cbrt takes. Far from the root
+// each step only shrinks the estimate by a third, so this needs to be larger
+// than sqrtMaxIter.
+const cbrtMaxIter = 2000
+
+// Cbrt returns an approximation to the cube root of x.
+//
+// Special cases are as for math.Cbrt:
+//
+//	Cbrt(±0) = ±0
+//	Cbrt(±Inf) = ±Inf
+//	Cbrt(NaN) = NaN
+func Cbrt(x float64) float64 {
+	if x == 0 || math.IsNaN(x) || math.IsInf(x, 0) {
+		return x
+	}
+
+	// Start with the sign of the root, so the iteration never has to cross 0.
+	z := 1.0
+	if x < 0 {
+		z = -1
+	}
+	for i := 0; i < cbrtMaxIter; i++ {
+		// This is the Newton step (z*z*z - x) / (3*z*z), rearranged so that
+		// large estimates don't overflow.
+		step := Sub(z, x/Mul(z, z)) / 3
+		z -= step
+		if abs(step) <= sqrtTol*abs(z) {
+			break
+		}
+	}
+	return z
+}
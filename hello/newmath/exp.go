@@ -0,0 +1,47 @@
+package newmath
+
+import "math"
+
+const (
+	// ln2Hi + ln2Lo is ln(2) to about twice float64 precision, split as in the
+	// math package so that k*ln2Hi is exact for the k Exp needs.
+	ln2Hi = 6.93147180369123816490e-01
+	ln2Lo = 1.90821492927058770002e-10
+
+	// Beyond these bounds Exp overflows to +Inf or underflows to 0.
+	expOverflow  = 7.09782712893383973096e+02
+	expUnderflow = -7.45133219101941108420e+02
+)
+
+// Exp returns an approximation to e**x, computed by a Taylor series.
+//
+// Special cases are as for math.Exp:
+//
+//	Exp(+Inf) = +Inf
+//	Exp(-Inf) = 0
+//	Exp(NaN) = NaN
+//
+// Very large values of x overflow to +Inf, and very small ones underflow to 0.
+func Exp(x float64) float64 {
+	switch {
+	case math.IsNaN(x):
+		return x
+	case x > expOverflow:
+		return math.Inf(1)
+	case x < expUnderflow:
+		return 0
+	}
+
+	// Write x as k*ln(2) + r with |r| <= ln(2)/2, so that e**x is 2**k * e**r
+	// and the series for e**r converges quickly.
+	k := math.Floor(x/math.Ln2 + 0.5)
+	r := (x - k*ln2Hi) - k*ln2Lo
+
+	// Add terms r**n / n! until they're too small to change the sum.
+	sum, term := 1.0, 1.0
+	for n := 1.0; sum+term != sum; n++ {
+		term = Mul(term, r/n)
+		sum += term
+	}
+	return math.Ldexp(sum, int(k))
+}
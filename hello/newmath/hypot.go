@@ -0,0 +1,31 @@
+package newmath
+
+import "math"
+
+// Hypot returns Sqrt(p*p + q*q), scaling the operands first so that the
+// squares can't overflow or underflow.
+//
+// Special cases are as for math.Hypot:
+//
+//	Hypot(±Inf, q) = +Inf
+//	Hypot(p, ±Inf) = +Inf
+//	Hypot(NaN, q) = NaN
+//	Hypot(p, NaN) = NaN
+func Hypot(p, q float64) float64 {
+	switch {
+	case math.IsInf(p, 0) || math.IsInf(q, 0):
+		return math.Inf(1)
+	case math.IsNaN(p) || math.IsNaN(q):
+		return math.NaN()
+	}
+
+	p, q = abs(p), abs(q)
+	if p < q {
+		p, q = q, p
+	}
+	if p == 0 {
+		return 0
+	}
+	q = q / p
+	return Mul(p, Sqrt(1+q*q))
+}
@@ -0,0 +1,23 @@
+package newmath
+
+import "math/bits"
+
+// Isqrt returns the integer square root of n: the largest z with z*z <= n.
+// Unlike converting Sqrt's result, it is exact for all n, including large
+// ones that a float64 can't represent.
+func Isqrt(n uint64) uint64 {
+	if n < 2 {
+		return n
+	}
+
+	// Newton's method on integers, starting from a power of two no smaller
+	// than the root. The estimates then decrease until they reach it.
+	z := uint64(1) << uint((bits.Len64(n)+1)/2)
+	for {
+		next := (z + n/z) / 2
+		if next >= z {
+			return z
+		}
+		z = next
+	}
+}
@@ -0,0 +1,49 @@
+package newmath
+
+import "math"
+
+// Log returns an approximation to the natural logarithm of x. After writing x
+// as m * 2**e, log(m) is summed from the series for 2*atanh((m-1)/(m+1)),
+// which is accurate to the last few bits even for x very close to 1.
+//
+// Special cases follow those of Sqrt rather than math.Log:
+//
+//	Log(+Inf) = +Inf
+//	Log(x <= 0) = NaN
+//	Log(NaN) = NaN
+func Log(x float64) float64 {
+	switch {
+	case math.IsNaN(x) || math.IsInf(x, 1):
+		return x
+	case x <= 0:
+		return math.NaN()
+	case x == 1:
+		return 0
+	}
+
+	// Write x as m * 2**e with 1/√2 <= m < √2, so that log(x) is
+	// e*ln(2) + log(m) and only log(m), which is then small, needs the
+	// series. Frexp gives 0.5 <= m < 1, which would make log(x) for x
+	// just above 1 the difference of two much larger terms.
+	m, e := math.Frexp(x)
+	if m < math.Sqrt2/2 {
+		m *= 2
+		e--
+	}
+
+	// log(m) = 2*(s + s**3/3 + s**5/5 + ...) for s = (m-1)/(m+1). Unlike
+	// solving e**z = m, where e**z and m agree in all but their last few
+	// digits, nothing cancels: m-1 is exact this close to 1. With |s| <=
+	// 0.172 each term is less than a thirtieth of the one before.
+	s := (m - 1) / (m + 1)
+	s2 := s * s
+	sum, term := s, s
+	for k := 3.0; ; k += 2 {
+		term *= s2
+		if sum+term/k == sum {
+			break
+		}
+		sum += term / k
+	}
+	return float64(e)*ln2Hi + (2*sum + float64(e)*ln2Lo)
+}
@@ -1,9 +1,40 @@
 package newmath
 
+import "math"
+
+// Add returns x + y.
+func Add(x, y float64) float64 {
+	return x + y
+}
+
+// Sub returns x - y.
+func Sub(x, y float64) float64 {
+	return x - y
+}
+
+// Mul returns x * y.
 func Mul(x, y float64) float64 {
 	return x * y
 }
 
-func minus(x, y float64) float64{
+// Div returns x / y. As for the / operator, dividing a nonzero x by zero gives
+// an infinity with the sign of x/y, and 0/0 gives NaN.
+func Div(x, y float64) float64 {
+	return x / y
+}
+
+// MulChecked returns a * b and true, or the wrapped-around product and false if
+// it overflows int64.
+func MulChecked(a, b int64) (int64, bool) {
+	c := a * b
+	// Dividing back recovers b unless the product overflowed, except for
+	// -1 * MinInt64, where the division overflows too.
+	if a != 0 && (c/a != b || a == -1 && b == math.MinInt64) {
+		return c, false
+	}
+	return c, true
+}
+
+func minus(x, y float64) float64 {
 	return x - y
 }
@@ -3,7 +3,12 @@ package newmath
 // Run: go test -v go-samples/hello/newmath
 // From GOPATH (note: no src/)
 
-import "testing"
+import (
+	"math"
+	"math/big"
+	"math/cmplx"
+	"testing"
+)
 
 func TestMul(t *testing.T) {
 	if Mul(2.0, 3.0) != 6.0 {
@@ -11,9 +16,495 @@ func TestMul(t *testing.T) {
 	}
 }
 
+func TestMulChecked(t *testing.T) {
+	tests := []struct {
+		a, b int64
+		want int64
+		ok   bool
+	}{
+		{2, 3, 6, true},
+		{-2, 3, -6, true},
+		{0, math.MinInt64, 0, true},
+		{math.MinInt64, 0, 0, true},
+		{math.MaxInt64, 1, math.MaxInt64, true},
+		{math.MinInt64, 1, math.MinInt64, true},
+		{math.MaxInt64, -1, -math.MaxInt64, true},
+		{1 << 31, 1 << 31, 1 << 62, true},
+		{-1 << 31, 1 << 32, math.MinInt64, true},
+		{1 << 32, 1 << 31, math.MinInt64, false},
+		{math.MaxInt64, 2, -2, false},
+		{math.MinInt64, -1, math.MinInt64, false},
+		{-1, math.MinInt64, math.MinInt64, false},
+		{math.MinInt64, math.MinInt64, 0, false},
+		{3037000500, 3037000500, -9223372036709301616, false},
+		{3037000499, 3037000499, 9223372030926249001, true},
+	}
+	for _, tt := range tests {
+		if got, ok := MulChecked(tt.a, tt.b); got != tt.want || ok != tt.ok {
+			t.Errorf("MulChecked(%d, %d) = %d, %t, want %d, %t", tt.a, tt.b, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
 // Can test private methods too because it's in the same package.
 func TestMinus(t *testing.T) {
 	if minus(4.0, 2.0) != 2.0 {
 		t.Error("minus failed")
 	}
 }
+
+func TestSqrt(t *testing.T) {
+	for _, x := range []float64{1e-300, 1e-10, 0.25, 1, 2, 3, 10, 12345.678, 1e10, 1e300, math.MaxFloat64} {
+		got, want := Sqrt(x), math.Sqrt(x)
+		// Written so that a NaN result fails.
+		if !(math.Abs(got-want) <= want*epsilon) {
+			t.Errorf("Sqrt(%g) = %g, want %g", x, got, want)
+		}
+	}
+}
+
+// epsilon is the difference between 1 and the next larger float64.
+const epsilon = 0x1p-52
+
+func TestSqrtFloat32(t *testing.T) {
+	for _, x := range []float32{1e-30, 1e-10, 0.25, 1, 2, 3, 10, 12345.678, 1e10, 1e30, math.MaxFloat32} {
+		got, want := SqrtOf(x), float32(math.Sqrt(float64(x)))
+		// Allow for rounding differently in the last place.
+		if got != want && got != math.Nextafter32(want, 0) && got != math.Nextafter32(want, math.MaxFloat32) {
+			t.Errorf("SqrtOf(float32(%g)) = %g, want %g", x, got, want)
+		}
+	}
+
+	type celsius float32
+	if got := SqrtOf(celsius(16)); got != 4 {
+		t.Errorf("SqrtOf(celsius(16)) = %g, want 4", got)
+	}
+	if got := SqrtOf(float32(-1)); !math.IsNaN(float64(got)) {
+		t.Errorf("SqrtOf(float32(-1)) = %g, want NaN", got)
+	}
+	if got := SqrtOf(float32(math.Inf(1))); !math.IsInf(float64(got), 1) {
+		t.Errorf("SqrtOf(float32(+Inf)) = %g, want +Inf", got)
+	}
+}
+
+func TestSqrtTrace(t *testing.T) {
+	for _, x := range []float64{0.25, 2, 1e10} {
+		var guesses []float64
+		got := SqrtTrace(x, func(iter int, guess float64) {
+			if iter != len(guesses)+1 {
+				t.Errorf("SqrtTrace(%g): iteration %d after %d guesses", x, iter, len(guesses))
+			}
+			guesses = append(guesses, guess)
+		})
+		if want := Sqrt(x); got != want {
+			t.Errorf("SqrtTrace(%g) = %g, want %g", x, got, want)
+		}
+		if len(guesses) == 0 || guesses[len(guesses)-1] != got {
+			t.Errorf("SqrtTrace(%g): last guess of %v isn't the result %g", x, guesses, got)
+			continue
+		}
+		root := math.Sqrt(x)
+		for i := 1; i < len(guesses); i++ {
+			if math.Abs(guesses[i]-root) > math.Abs(guesses[i-1]-root) {
+				t.Errorf("SqrtTrace(%g): guess %d (%g) is further from the root than %g", x, i+1, guesses[i], guesses[i-1])
+			}
+		}
+	}
+
+	if got := SqrtTrace(2, nil); got != Sqrt(2) {
+		t.Errorf("SqrtTrace(2, nil) = %g, want %g", got, Sqrt(2))
+	}
+	SqrtTrace(-1, func(int, float64) { t.Error("step called for a special case") })
+}
+
+func TestSqrtSpecialCases(t *testing.T) {
+	tests := []struct {
+		x, want float64
+	}{
+		{0, 0},
+		{math.Copysign(0, -1), math.Copysign(0, -1)},
+		{math.Inf(1), math.Inf(1)},
+		{-4, math.NaN()},
+		{math.Inf(-1), math.NaN()},
+		{math.NaN(), math.NaN()},
+	}
+	for _, tt := range tests {
+		got := Sqrt(tt.x)
+		if math.IsNaN(tt.want) {
+			if !math.IsNaN(got) {
+				t.Errorf("Sqrt(%g) = %g, want NaN", tt.x, got)
+			}
+		} else if got != tt.want || math.Signbit(got) != math.Signbit(tt.want) {
+			t.Errorf("Sqrt(%g) = %g, want %g", tt.x, got, tt.want)
+		}
+	}
+}
+
+func TestSqrtErr(t *testing.T) {
+	if z, err := SqrtErr(4); err != nil || z != 2 {
+		t.Errorf("SqrtErr(4) = %g, %v, want 2, nil", z, err)
+	}
+	if _, err := SqrtErr(-4); err != ErrNegativeSqrt {
+		t.Errorf("SqrtErr(-4) error = %v, want ErrNegativeSqrt", err)
+	}
+	if _, err := SqrtErr(math.Inf(-1)); err != ErrNegativeSqrt {
+		t.Errorf("SqrtErr(-Inf) error = %v, want ErrNegativeSqrt", err)
+	}
+}
+
+func TestSqrtPrec(t *testing.T) {
+	loose, nLoose, _ := SqrtPrec(2, 1e-3, 100)
+	tight, nTight, ok := SqrtPrec(2, 1e-15, 100)
+	if !ok {
+		t.Error("SqrtPrec(2) didn't converge")
+	}
+	if nTight <= nLoose {
+		t.Errorf("tolerance 1e-15 took %d iterations, no more than 1e-3's %d", nTight, nLoose)
+	}
+	if math.Abs(loose-math.Sqrt2) > 1e-3 || math.Abs(tight-math.Sqrt2) > math.Sqrt2*epsilon {
+		t.Errorf("SqrtPrec(2) = %g and %g, want %g", loose, tight, math.Sqrt2)
+	}
+	if _, n, ok := SqrtPrec(1e300, 1e-15, 2); n != 2 || ok {
+		t.Errorf("SqrtPrec(1e300) with maxIter 2 = %d iterations, converged %v; want 2, false", n, ok)
+	}
+	if _, n, ok := SqrtPrec(0, 1e-15, 100); n != 0 || !ok {
+		t.Errorf("SqrtPrec(0) = %d iterations, converged %v; want 0, true", n, ok)
+	}
+}
+
+func TestSqrtGuess(t *testing.T) {
+	// Sqrt's own guess gets any input to the root in a few steps.
+	for _, x := range []float64{5e-324, 1e-300, 0.25, 2, 1e10, 1e300, math.MaxFloat64} {
+		if _, n, ok := SqrtPrec(x, sqrtTol, 10); !ok {
+			t.Errorf("SqrtPrec(%g) didn't converge in 10 steps", x)
+		} else if n > 7 {
+			t.Errorf("SqrtPrec(%g) took %d steps, want at most 7", x, n)
+		}
+	}
+
+	for _, tt := range []struct{ x, guess float64 }{
+		{2, 1.4}, {2, 1}, {2, 1e10}, {1e300, 1}, {1e300, 1e150},
+		{2, 0}, {2, -1}, {2, math.Inf(1)}, {2, math.NaN()},
+		// Guesses so far off that x/guess overflows, or that need
+		// the most halvings to reach the root.
+		{4, 1e-320}, {1e300, 1e-10}, {1e300, 1e-300}, {math.MaxFloat64, 5e-324},
+		{1e-300, 1e300}, {5e-324, math.MaxFloat64}, {1e-300, 1e-300},
+	} {
+		got, want := SqrtGuess(tt.x, tt.guess), math.Sqrt(tt.x)
+		if !(math.Abs(got-want) <= want*epsilon) {
+			t.Errorf("SqrtGuess(%g, %g) = %g, want %g", tt.x, tt.guess, got, want)
+		}
+	}
+	if got := SqrtGuess(-1, 1); !math.IsNaN(got) {
+		t.Errorf("SqrtGuess(-1, 1) = %g, want NaN", got)
+	}
+}
+
+func TestCbrt(t *testing.T) {
+	for _, x := range []float64{1e-300, 0.001, 1, 2, 8, 27, 1e10, 1e300, math.MaxFloat64} {
+		for _, x := range []float64{x, -x} {
+			got, want := Cbrt(x), math.Cbrt(x)
+			if math.Abs(got-want) > math.Abs(want)*epsilon {
+				t.Errorf("Cbrt(%g) = %g, want %g", x, got, want)
+			}
+		}
+	}
+	if got := Cbrt(-8); got != -2 {
+		t.Errorf("Cbrt(-8) = %g, want -2", got)
+	}
+	for _, x := range []float64{0, math.Copysign(0, -1), math.Inf(1), math.Inf(-1)} {
+		if got := Cbrt(x); got != x || math.Signbit(got) != math.Signbit(x) {
+			t.Errorf("Cbrt(%g) = %g, want %g", x, got, x)
+		}
+	}
+	if got := Cbrt(math.NaN()); !math.IsNaN(got) {
+		t.Errorf("Cbrt(NaN) = %g, want NaN", got)
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	if Add(2.0, 3.0) != 5.0 {
+		t.Error("Add failed")
+	}
+	if Sub(2.0, 3.0) != -1.0 || Sub(4.0, 2.0) != 2.0 {
+		t.Error("Sub failed")
+	}
+	if Div(3.0, 2.0) != 1.5 {
+		t.Error("Div failed")
+	}
+	if !math.IsInf(Div(1, 0), 1) || !math.IsInf(Div(-1, 0), -1) || !math.IsNaN(Div(0, 0)) {
+		t.Error("Div by zero failed")
+	}
+}
+
+func TestIsqrt(t *testing.T) {
+	check := func(n uint64) {
+		z := Isqrt(n)
+		// (z+1)² > n is checked as z+1 > n/(z+1) to avoid overflow.
+		if z*z > n || z+1 <= n/(z+1) || z > math.MaxUint32 {
+			t.Errorf("Isqrt(%d) = %d", n, z)
+		}
+	}
+	for n := uint64(0); n < 1000; n++ {
+		check(n)
+	}
+	for _, r := range []uint64{1 << 16, 94906265, 1<<32 - 1} {
+		for _, n := range []uint64{r*r - 1, r * r, r*r + 1} {
+			check(n)
+		}
+	}
+	for n := uint64(math.MaxUint64); n > math.MaxUint64-1000; n-- {
+		check(n)
+	}
+	if z := Isqrt(math.MaxUint64); z != 1<<32-1 {
+		t.Errorf("Isqrt(MaxUint64) = %d, want %d", z, uint64(1<<32-1))
+	}
+}
+
+func TestPow(t *testing.T) {
+	tests := []struct {
+		x, y float64
+	}{
+		{2, 10}, {2, -3}, {-2, 3}, {-2, 4}, {-2, -3}, {1.5, 25}, {10, 300}, {0.5, 1100},
+		{2, 0.5}, {10, -0.25}, {7.5, 3.3},
+		{5, 0}, {math.NaN(), 0}, {-3, math.Copysign(0, -1)},
+		{0, 3}, {0, -3}, {math.Copysign(0, -1), 3}, {math.Copysign(0, -1), -3}, {0, 2.5}, {0, -2.5},
+		{-8, 1.0 / 3}, {math.Inf(1), 2}, {math.Inf(-1), 3}, {2, math.Inf(1)}, {0.5, math.Inf(1)},
+		{2, -1074}, {-2, -1073}, {10, -320}, {2, -1080}, {0.5, -1100},
+		{1, math.NaN()}, {1, math.Inf(-1)}, {0, math.NaN()}, {math.NaN(), 2}, {2, math.NaN()},
+	}
+	for _, tt := range tests {
+		got, want := Pow(tt.x, tt.y), math.Pow(tt.x, tt.y)
+		switch {
+		case math.IsNaN(want):
+			if !math.IsNaN(got) {
+				t.Errorf("Pow(%g, %g) = %g, want NaN", tt.x, tt.y, got)
+			}
+		case want == 0 || math.IsInf(want, 0):
+			if got != want || math.Signbit(got) != math.Signbit(want) {
+				t.Errorf("Pow(%g, %g) = %g, want %g", tt.x, tt.y, got, want)
+			}
+		default:
+			if math.Abs(got-want) > math.Abs(want)*1e-14 {
+				t.Errorf("Pow(%g, %g) = %g, want %g", tt.x, tt.y, got, want)
+			}
+		}
+	}
+}
+
+func TestExp(t *testing.T) {
+	for _, x := range []float64{0, 1e-10, -1e-10, 0.5, 1, -1, 2.5, 10, -10, 100, -100, 700, -700, 709.7, -708} {
+		got, want := Exp(x), math.Exp(x)
+		if !(math.Abs(got-want) <= want*1e-14) {
+			t.Errorf("Exp(%g) = %g, want %g", x, got, want)
+		}
+	}
+	for _, tt := range []struct{ x, want float64 }{
+		{710, math.Inf(1)}, {1e300, math.Inf(1)}, {math.Inf(1), math.Inf(1)},
+		{-746, 0}, {-1e300, 0}, {math.Inf(-1), 0},
+	} {
+		if got := Exp(tt.x); got != tt.want {
+			t.Errorf("Exp(%g) = %g, want %g", tt.x, got, tt.want)
+		}
+	}
+	if got := Exp(math.NaN()); !math.IsNaN(got) {
+		t.Errorf("Exp(NaN) = %g, want NaN", got)
+	}
+	// Near the underflow bound the result is subnormal and loses precision.
+	if got, want := Exp(-740), math.Exp(-740); math.Abs(got-want) > want*1e-6 {
+		t.Errorf("Exp(-740) = %g, want %g", got, want)
+	}
+}
+
+func TestLog(t *testing.T) {
+	for _, x := range []float64{
+		1e-300, 1e-10, 0.1, 0.5, 0.9999, 1, 1.0001, 2, math.E, 10, 12345.678, 1e300, math.MaxFloat64,
+		// Near 1 the result is small, so only a relative error will do.
+		1 + 1e-6, 1 + 1e-10, 1 - 1e-8, 1 + 0x1p-52, 1 - 0x1p-53, math.Sqrt2 / 2, math.Sqrt2,
+	} {
+		got, want := Log(x), math.Log(x)
+		if !(math.Abs(got-want) <= math.Abs(want)*1e-14) {
+			t.Errorf("Log(%g) = %g, want %g", x, got, want)
+		}
+	}
+	for _, x := range []float64{0, math.Copysign(0, -1), -1, math.Inf(-1), math.NaN()} {
+		if got := Log(x); !math.IsNaN(got) {
+			t.Errorf("Log(%g) = %g, want NaN", x, got)
+		}
+	}
+	// The smallest subnormal is exactly 2**-1074.
+	if got, want := Log(5e-324), -1074*math.Ln2; math.Abs(got-want) > -want*1e-14 {
+		t.Errorf("Log(5e-324) = %g, want %g", got, want)
+	}
+	if got := Log(math.Inf(1)); !math.IsInf(got, 1) {
+		t.Errorf("Log(+Inf) = %g, want +Inf", got)
+	}
+	if got := Log(1); got != 0 || math.Signbit(got) {
+		t.Errorf("Log(1) = %g, want 0", got)
+	}
+	if got := Pow(1, 0.5); got != 1 {
+		t.Errorf("Pow(1, 0.5) = %g, want 1", got)
+	}
+}
+
+func TestSqrtSlice(t *testing.T) {
+	src := []float64{0, 1, 4, 9, 2}
+	dst := make([]float64, len(src)+1)
+	SqrtSlice(dst, src)
+	for i, x := range src {
+		if dst[i] != Sqrt(x) {
+			t.Errorf("dst[%d] = %g, want %g", i, dst[i], Sqrt(x))
+		}
+	}
+	if dst[len(src)] != 0 {
+		t.Errorf("SqrtSlice wrote past len(src): %v", dst)
+	}
+
+	SqrtInPlace(src)
+	for i := range src {
+		if src[i] != dst[i] {
+			t.Errorf("SqrtInPlace: s[%d] = %g, want %g", i, src[i], dst[i])
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("SqrtSlice didn't panic with a short destination")
+		}
+	}()
+	SqrtSlice(dst[:2], dst)
+}
+
+func benchInput(n int) []float64 {
+	s := make([]float64, n)
+	for i := range s {
+		s[i] = float64(i) + 0.5
+	}
+	return s
+}
+
+func BenchmarkSqrtLoop(b *testing.B) {
+	src := benchInput(1000)
+	dst := make([]float64, len(src))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j, x := range src {
+			dst[j] = Sqrt(x)
+		}
+	}
+}
+
+// BenchmarkSqrtGuess compares starting from 1 with Sqrt's own initial guess,
+// reporting the number of Newton steps each takes for a large input.
+func BenchmarkSqrtGuess(b *testing.B) {
+	const x = 1e300
+	for _, bm := range []struct {
+		name  string
+		guess float64
+	}{
+		{"One", 1},
+		{"Default", 0},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			var iters int
+			for i := 0; i < b.N; i++ {
+				_, iters, _ = sqrtPrec(x, bm.guess, sqrtTol, sqrtMaxIter, nil)
+			}
+			b.ReportMetric(float64(iters), "iters/op")
+		})
+	}
+}
+
+func BenchmarkSqrtSlice(b *testing.B) {
+	src := benchInput(1000)
+	dst := make([]float64, len(src))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		SqrtSlice(dst, src)
+	}
+}
+
+func TestSqrtBig(t *testing.T) {
+	const sqrt2 = "1.4142135623730950488016887242096980785696718753769"
+	x := new(big.Float).SetPrec(200).SetInt64(2)
+	z := SqrtBig(x)
+	if s := z.Text('f', 49); s != sqrt2 {
+		t.Errorf("SqrtBig(2) = %s, want %s", s, sqrt2)
+	}
+	if z.Prec() != 200 || x.Cmp(big.NewFloat(2)) != 0 {
+		t.Errorf("SqrtBig(2) has precision %d and changed x to %v", z.Prec(), x)
+	}
+
+	for _, f := range []float64{0, 0.25, 1, 3, 1e300, 1e-300, math.Inf(1)} {
+		got, _ := SqrtBig(big.NewFloat(f)).Float64()
+		if want := math.Sqrt(f); got != want {
+			t.Errorf("SqrtBig(%g) = %g, want %g", f, got, want)
+		}
+	}
+
+	defer func() {
+		if r := recover(); r != ErrNegativeSqrt {
+			t.Errorf("SqrtBig(-1) panicked with %v, want ErrNegativeSqrt", r)
+		}
+	}()
+	SqrtBig(big.NewFloat(-1))
+}
+
+func TestSqrtComplex(t *testing.T) {
+	tests := []complex128{
+		3 + 4i, -3 + 4i, -3 - 4i, 3 - 4i,
+		2, 1i, -1i, 0.5 + 1e-10i, 1e300 + 1e300i, -1e-300 + 1e-300i,
+		5e-324 + 5e-324i, -5e-324 - 5e-324i, 3e-310 - 1e-320i,
+		1.5e308 + 1.5e308i, -1.5e308 + 1e308i, 1e308 - 1.7e308i,
+		complex(math.MaxFloat64, math.MaxFloat64), complex(-math.MaxFloat64, -math.MaxFloat64),
+		complex(math.MaxFloat64, 1), complex(-math.MaxFloat64, 1e-300),
+	}
+	for _, z := range tests {
+		got, want := SqrtComplex(z), cmplx.Sqrt(z)
+		if cmplx.Abs(got-want) > cmplx.Abs(want)*1e-15 {
+			t.Errorf("SqrtComplex(%v) = %v, want %v", z, got, want)
+		}
+	}
+
+	exact := []struct {
+		z, want complex128
+	}{
+		{-1, 1i},
+		{-4, 2i},
+		{complex(-4, math.Copysign(0, -1)), -2i},
+		{0, 0},
+	}
+	for _, tt := range exact {
+		if got := SqrtComplex(tt.z); got != tt.want || math.Signbit(imag(got)) != math.Signbit(imag(tt.want)) {
+			t.Errorf("SqrtComplex(%v) = %v, want %v", tt.z, got, tt.want)
+		}
+	}
+}
+
+func TestHypot(t *testing.T) {
+	tests := []struct {
+		p, q float64
+	}{
+		{3, 4}, {-3, 4}, {0, 0}, {0, -5}, {1, 1e-20},
+		{1e300, 1e300}, {math.MaxFloat64, 1}, {-1e308, 1e308},
+		{1e-300, 1e-300}, {5e-324, 5e-324}, {3e-320, 4e-320},
+	}
+	for _, tt := range tests {
+		got, want := Hypot(tt.p, tt.q), math.Hypot(tt.p, tt.q)
+		if math.Abs(got-want) > want*2*epsilon {
+			t.Errorf("Hypot(%g, %g) = %g, want %g", tt.p, tt.q, got, want)
+		}
+	}
+
+	inf, nan := math.Inf(1), math.NaN()
+	for _, pq := range [][2]float64{{inf, 1}, {1, -inf}, {-inf, nan}, {nan, inf}} {
+		if got := Hypot(pq[0], pq[1]); !math.IsInf(got, 1) {
+			t.Errorf("Hypot(%g, %g) = %g, want +Inf", pq[0], pq[1], got)
+		}
+	}
+	for _, pq := range [][2]float64{{nan, 1}, {1, nan}} {
+		if got := Hypot(pq[0], pq[1]); !math.IsNaN(got) {
+			t.Errorf("Hypot(%g, %g) = %g, want NaN", pq[0], pq[1], got)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package newmath
+
+import "math"
+
+// Pow returns x**y. Integer exponents are computed by repeated squaring, so
+// negative bases work for them; otherwise Pow uses Exp(y*Log(x)), and a
+// negative x gives NaN. Each multiply rounds, so the result isn't exact, but
+// it matches math.Pow to within a relative 1e-14 for the inputs tested.
+//
+// Special cases:
+//
+//	Pow(x, ±0) = 1 for any x
+//	Pow(1, y) = 1 for any y
+//	Pow(±0, y) = ±Inf for odd integer y < 0, with the sign of x
+//	Pow(±0, y) = ±0 for odd integer y > 0, with the sign of x
+//	Pow(±0, y) = +Inf for other y < 0 and +0 for other y > 0
+//	Pow(x < 0, y) = NaN for non-integer y
+//	Pow(x, y) = NaN if x or y is NaN, except as above
+//
+// Infinite exponents are handled as by math.Pow.
+func Pow(x, y float64) float64 {
+	switch {
+	case y == 0 || x == 1:
+		return 1
+	case math.IsNaN(x) || math.IsNaN(y):
+		return math.NaN()
+	case math.IsInf(y, 0) || math.Abs(y) >= 1<<63:
+		return math.Pow(x, y)
+	case y == math.Trunc(y):
+		if y < 0 {
+			// 1/x**n is more accurate than (1/x)**n, as 1/x is usually
+			// rounded, but x**n may overflow even though its reciprocal is
+			// still representable (as a subnormal).
+			n := uint64(-y)
+			if z := powInt(x, n); !math.IsInf(z, 0) {
+				return 1 / z
+			}
+			return powInt(1/x, n)
+		}
+		return powInt(x, uint64(y))
+	case x < 0:
+		return math.NaN()
+	case x == 0:
+		if y < 0 {
+			return math.Inf(1)
+		}
+		return 0
+	}
+	return Exp(Mul(y, Log(x)))
+}
+
+// powInt returns x**n by exponentiation by squaring: x**n is (x*x)**(n/2),
+// times another x if n is odd.
+func powInt(x float64, n uint64) float64 {
+	z := 1.0
+	for n > 0 {
+		if n%2 == 1 {
+			z = Mul(z, x)
+		}
+		x = Mul(x, x)
+		n /= 2
+	}
+	return z
+}
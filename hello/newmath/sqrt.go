@@ -1,13 +1,155 @@
 // Package newmath is a trivial example package.
 package newmath
 
+import (
+	"errors"
+	"math"
+)
+
+const (
+	// sqrtTol is the relative size of a Newton step below which Sqrt
+	// considers the iteration converged. See SqrtPrec.
+	sqrtTol = 1e-15
+
+	// sqrtTol32 is the equivalent of sqrtTol for float32, whose precision is
+	// too low to ever take a step as small as sqrtTol.
+	sqrtTol32 = 5e-7
+
+	// sqrtMaxIter bounds the number of Newton steps Sqrt takes. Sqrt's own
+	// initial guess needs only a few, but from a poor guess passed to
+	// SqrtGuess each step may only halve the distance to a far-off root.
+	// Getting from MaxFloat64 down to the root of the smallest subnormal
+	// takes about 1560 halvings, plus a few steps to converge.
+	sqrtMaxIter = 1600
+)
+
 // Sqrt returns an approximation to the square root of x.
+//
+// Special cases are as for math.Sqrt:
+//
+//	Sqrt(+Inf) = +Inf
+//	Sqrt(±0) = ±0
+//	Sqrt(x < 0) = NaN
+//	Sqrt(NaN) = NaN
 func Sqrt(x float64) float64 {
-	z := 1.0
-	for i := 0; i < 1000; i++ {
-		// Can see both exported and non-exported symbols from mul.go, because
-		// it's all in the same package.
-		z -= minus(z*z, x) / Mul(2, z)
+	return SqrtOf(x)
+}
+
+// SqrtOf is the generic form of Sqrt, for any floating-point type. The
+// iteration is done in the precision of T, so for a float32 x the result is
+// close to float32(math.Sqrt(float64(x))).
+func SqrtOf[T ~float32 | ~float64](x T) T {
+	tol := T(sqrtTol)
+	if T(1)+T(1e-10) == 1 {
+		// T is too imprecise to tell 1e-10 from 0 next to 1, so it's
+		// float32.
+		tol = sqrtTol32
+	}
+	z, _, _ := sqrtPrec(x, 0, tol, sqrtMaxIter, nil)
+	return z
+}
+
+// SqrtGuess is like Sqrt, but starts the iteration from guess rather than
+// Sqrt's own estimate. The closer guess is to the root, the fewer steps are
+// needed. A guess that isn't positive and finite, or is so small that x/guess
+// overflows, is ignored.
+func SqrtGuess(x, guess float64) float64 {
+	if math.IsInf(guess, 0) {
+		guess = 0
 	}
+	z, _, _ := sqrtPrec(x, guess, sqrtTol, sqrtMaxIter, nil)
 	return z
 }
+
+// sqrtGuess returns the initial estimate for the square root of a positive,
+// finite x: 2**(e/2) for x = m * 2**e, which is within a factor of two of the
+// root.
+func sqrtGuess(x float64) float64 {
+	_, e := math.Frexp(x)
+	return math.Ldexp(1, e/2)
+}
+
+// SqrtTrace computes Sqrt(x), calling step with each successive approximation
+// and the number of Newton steps taken so far, starting from 1. The last call
+// is for the returned value. There are no calls for the special cases listed
+// for Sqrt. A nil step makes SqrtTrace the same as Sqrt.
+func SqrtTrace(x float64, step func(iter int, guess float64)) float64 {
+	z, _, _ := sqrtPrec(x, 0, sqrtTol, sqrtMaxIter, step)
+	return z
+}
+
+// SqrtPrec computes the square root of x like Sqrt, but stops once a Newton
+// step is smaller than tol relative to the current estimate, or after maxIter
+// steps. It returns the estimate, the number of steps taken, which is 0 for
+// the special cases listed for Sqrt, and whether the iteration converged. If it
+// didn't, the estimate may be far from the root.
+func SqrtPrec(x, tol float64, maxIter int) (z float64, iters int, converged bool) {
+	return sqrtPrec(x, 0, tol, maxIter, nil)
+}
+
+// sqrtPrec implements SqrtPrec for any floating-point type, starting from
+// guess if it's usable and from sqrtGuess otherwise, and calling trace (if
+// it isn't nil) after each step as described for SqrtTrace.
+func sqrtPrec[T ~float32 | ~float64](x, guess, tol T, maxIter int, trace func(int, T)) (z T, iters int, converged bool) {
+	switch {
+	case x == 0 || math.IsNaN(float64(x)) || math.IsInf(float64(x), 1):
+		return x, 0, true
+	case x < 0:
+		return T(math.NaN()), 0, true
+	}
+
+	z = guess
+	if !(z > 0) || math.IsInf(float64(x/z), 0) {
+		z = T(sqrtGuess(float64(x)))
+	}
+	for iters < maxIter {
+		// This is (z*z - x) / (2*z), rearranged so that z*z can't overflow
+		// for large x. Sub comes from mul.go and takes float64, but
+		// rounding its result to T gives the same as subtracting in T.
+		step := T(Sub(float64(z), float64(x/z))) / 2
+		z -= step
+		iters++
+		if trace != nil {
+			trace(iters, z)
+		}
+		if abs(step) <= tol*z && !math.IsInf(float64(z), 0) {
+			return z, iters, true
+		}
+	}
+	return z, iters, false
+}
+
+func abs[T ~float32 | ~float64](x T) T {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// ErrNegativeSqrt is returned by SqrtErr for negative inputs.
+var ErrNegativeSqrt = errors.New("newmath: square root of negative number")
+
+// SqrtErr is like Sqrt, but returns ErrNegativeSqrt instead of NaN if x is
+// negative.
+func SqrtErr(x float64) (float64, error) {
+	if x < 0 {
+		return 0, ErrNegativeSqrt
+	}
+	return Sqrt(x), nil
+}
+
+// SqrtSlice sets dst[i] to Sqrt(src[i]) for each element of src. It panics if
+// dst is shorter than src. dst and src may be the same slice.
+func SqrtSlice(dst, src []float64) {
+	if len(dst) < len(src) {
+		panic("newmath: SqrtSlice destination shorter than source")
+	}
+	for i, x := range src {
+		dst[i] = Sqrt(x)
+	}
+}
+
+// SqrtInPlace replaces each element of s with its square root.
+func SqrtInPlace(s []float64) {
+	SqrtSlice(s, s)
+}
@@ -0,0 +1,44 @@
+package newmath
+
+import "math/big"
+
+// sqrtBigGuard is the number of extra bits of precision SqrtBig works with,
+// so that rounding errors in the iteration don't show in the result.
+const sqrtBigGuard = 32
+
+// SqrtBig returns a new big.Float holding the square root of x, rounded to
+// the precision of x (or 53 bits if x has precision 0). Like SqrtErr it
+// doesn't accept negative numbers: it panics with ErrNegativeSqrt for them.
+func SqrtBig(x *big.Float) *big.Float {
+	prec := x.Prec()
+	if prec == 0 {
+		prec = 53
+	}
+	switch {
+	case x.Sign() < 0:
+		panic(ErrNegativeSqrt)
+	case x.Sign() == 0 || x.IsInf():
+		return new(big.Float).SetPrec(prec).Set(x)
+	}
+
+	// Start from a power of two within a factor of two of the root, and work
+	// with a few guard bits.
+	work := prec + sqrtBigGuard
+	z := new(big.Float).SetMantExp(big.NewFloat(1), x.MantExp(nil)/2)
+	z.SetPrec(work)
+	half := big.NewFloat(0.5)
+	q := new(big.Float).SetPrec(work)
+	step := new(big.Float).SetPrec(work)
+	for i := 0; i < sqrtMaxIter; i++ {
+		// step = (z - x/z)/2, the same Newton step as Sqrt's.
+		q.Quo(x, z)
+		step.Sub(z, q)
+		step.Mul(step, half)
+		z.Sub(z, step)
+		// Stop once the step no longer changes z at the result's precision.
+		if step.Sign() == 0 || step.MantExp(nil) < z.MantExp(nil)-int(prec)-1 {
+			break
+		}
+	}
+	return z.SetPrec(prec)
+}
@@ -0,0 +1,38 @@
+package newmath
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// SqrtComplex returns the principal square root of z: the root with a
+// non-negative real part, and for a negative real z, a positive imaginary part
+// unless imag(z) is -0. Infinite and NaN parts are handled as by cmplx.Sqrt.
+func SqrtComplex(z complex128) complex128 {
+	a, b := real(z), imag(z)
+	switch {
+	case cmplx.IsInf(z) || cmplx.IsNaN(z):
+		return cmplx.Sqrt(z)
+	case a == 0 && b == 0:
+		return complex(0, b)
+	case math.Abs(a) < 0x1p-1000 && math.Abs(b) < 0x1p-1000:
+		// Halving tiny parts below would lose precision or underflow to 0,
+		// so scale z up first, exactly, by an even power of two:
+		// √(4**k z) = 2**k √z.
+		w := SqrtComplex(complex(a*0x1p1000, b*0x1p1000))
+		return complex(real(w)*0x1p-500, imag(w)*0x1p-500)
+	case math.Abs(a) > math.MaxFloat64/4 || math.Abs(b) > math.MaxFloat64/4:
+		// Likewise, |z| overflows for huge parts, so scale z down:
+		// √(z/4) = √z/2.
+		w := SqrtComplex(complex(a/4, b/4))
+		return complex(real(w)*2, imag(w)*2)
+	}
+
+	// With t = √((|z| + |a|)/2), the root is t + bi/2t for a >= 0, and
+	// |b|/2t ± ti otherwise. Halving before adding avoids overflow.
+	t := Sqrt(Hypot(a, b)/2 + math.Abs(a)/2)
+	if a >= 0 {
+		return complex(t, b/(2*t))
+	}
+	return complex(math.Abs(b)/(2*t), math.Copysign(t, b))
+}
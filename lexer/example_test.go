@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// A custom scanner for "@{...}" template placeholders, which the lexer leaves
+// to the caller.
+func ExampleLexer_Advance() {
+	lex := NewLexer([]byte("x = @{user.name} + 1"))
+	for {
+		for lex.Rune() == ' ' {
+			lex.Advance()
+		}
+		if lex.Rune() == '@' {
+			start := lex.Offset()
+			for lex.Rune() >= 0 && lex.Rune() != '}' {
+				lex.Advance()
+			}
+			lex.Advance()
+			fmt.Println("PLACEHOLDER", start, lex.Offset())
+			continue
+		}
+		tok := lex.NextToken()
+		if tok.Name == EOF {
+			break
+		}
+		fmt.Println(tok.Name, tok.Val)
+	}
+	// Output:
+	// IDENTIFIER x
+	// EQUALS =
+	// PLACEHOLDER 4 16
+	// PLUS +
+	// NUMBER 1
+}
+
+func ExampleDumpTokens() {
+	DumpTokens(os.Stdout, Lex([]byte("let s = \"ä\\t\";\n^")))
+	// Output:
+	// NAME        VALUE       POS   OFFSET
+	// IDENTIFIER  "let"       1:1   0
+	// IDENTIFIER  "s"         1:5   4
+	// EQUALS      "="         1:7   6
+	// QUOTE       "\"ä\\t\""  1:9   8
+	// SEMI        ";"         1:14  14
+	// ERROR       ""          2:1   16  unexpected character '^'
+}
@@ -0,0 +1,85 @@
+// JSON encoding of tokens, e.g. for feeding token streams to other tools.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// MarshalJSON encodes n as its mnemonic name, e.g. "IDENTIFIER". A name
+// without a mnemonic, such as one defined by the caller for the Operators or
+// Keywords options, is encoded as its number so that it can be decoded again.
+func (n TokenName) MarshalJSON() ([]byte, error) {
+	if _, ok := ParseTokenName(n.String()); !ok {
+		return json.Marshal(int(n))
+	}
+	return json.Marshal(n.String())
+}
+
+// UnmarshalJSON decodes a mnemonic name or a number, as produced by
+// MarshalJSON.
+func (n *TokenName) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var i int
+		if json.Unmarshal(data, &i) != nil {
+			return err
+		}
+		*n = TokenName(i)
+		return nil
+	}
+	name, ok := ParseTokenName(s)
+	if !ok {
+		return fmt.Errorf("unknown token name %q", s)
+	}
+	*n = name
+	return nil
+}
+
+// tokenJSON is the JSON form of a Token, with the fields of Pos flattened.
+type tokenJSON struct {
+	Name  TokenName `json:"name"`
+	Val   string    `json:"val"`
+	Pos   int       `json:"pos"`
+	Line  int       `json:"line"`
+	Col   int       `json:"col"`
+	Runes int       `json:"rune_offset,omitempty"`
+	Msg   string    `json:"msg,omitempty"`
+	Unit  string    `json:"unit,omitempty"`
+	Trunc bool      `json:"truncated,omitempty"`
+}
+
+// MarshalJSON encodes tok as an object like
+// {"name":"IDENTIFIER","val":"foo","pos":0,"line":1,"col":1}, with the name
+// in its string form. A value held in Bytes is encoded as "val" too.
+func (tok Token) MarshalJSON() ([]byte, error) {
+	return json.Marshal(tokenJSON{
+		Name:  tok.Name,
+		Val:   tok.ValString(),
+		Pos:   tok.Pos.Offset,
+		Line:  tok.Pos.Line,
+		Col:   tok.Pos.Col,
+		Runes: tok.RuneOffset,
+		Msg:   tok.Msg,
+		Unit:  tok.Unit,
+		Trunc: tok.Truncated,
+	})
+}
+
+// UnmarshalJSON decodes a token encoded by MarshalJSON.
+func (tok *Token) UnmarshalJSON(data []byte) error {
+	var tj tokenJSON
+	if err := json.Unmarshal(data, &tj); err != nil {
+		return err
+	}
+	*tok = Token{
+		Name:       tj.Name,
+		Val:        tj.Val,
+		Pos:        Position{tj.Pos, tj.Line, tj.Col},
+		RuneOffset: tj.Runes,
+		Msg:        tj.Msg,
+		Unit:       tj.Unit,
+		Truncated:  tj.Trunc,
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestTokenJSON(t *testing.T) {
+	tok := Token{Name: IDENTIFIER, Val: "foo", Pos: Position{0, 1, 1}}
+	data, err := json.Marshal(tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"name":"IDENTIFIER","val":"foo","pos":0,"line":1,"col":1}`; string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+
+	// Round-tripping a token stream is lossless.
+	toks := Lex([]byte("def x = \"本ä\\n\"; // c\n'a' \"open"))
+	data, err = json.Marshal(toks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []Token
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(toks) {
+		t.Fatalf("got %d tokens, want %d", len(got), len(toks))
+	}
+	for i := range toks {
+		if !reflect.DeepEqual(got[i], toks[i]) {
+			t.Errorf("token %d: got %#v, want %#v", i, got[i], toks[i])
+		}
+	}
+}
+
+func TestTokenNameJSON(t *testing.T) {
+	data, err := json.Marshal([]TokenName{L_PAREN, EOF})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `["L_PAREN","EOF"]`; string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+
+	var n TokenName
+	if err := json.Unmarshal([]byte(`"R_BRACE"`), &n); err != nil || n != R_BRACE {
+		t.Errorf("got %v, %v; want R_BRACE", n, err)
+	}
+	if err := json.Unmarshal([]byte(`"NOPE"`), &n); err == nil {
+		t.Error("unmarshaling an unknown name succeeded")
+	}
+	if err := json.Unmarshal([]byte(`true`), &n); err == nil {
+		t.Error("unmarshaling a boolean succeeded")
+	}
+
+	// Names without a mnemonic, as a caller might define, round-trip as
+	// numbers.
+	const custom = TokenName(1000)
+	tok := Token{Name: custom, Val: "~~"}
+	data, err = json.Marshal(tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"name":1000,"val":"~~","pos":0,"line":0,"col":0}`; string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+	var got Token
+	if err := json.Unmarshal(data, &got); err != nil || !reflect.DeepEqual(got, tok) {
+		t.Errorf("round trip gave %v, %v; want %v", got, err, tok)
+	}
+	if err := json.Unmarshal([]byte(`3`), &n); err != nil || n != TokenName(3) {
+		t.Errorf("got %v, %v; want TokenName(3)", n, err)
+	}
+}
@@ -2,8 +2,22 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
+	"unicode/utf8"
+	"unsafe"
 )
 
 var input = "/tmp/input.td"
@@ -58,3 +72,2751 @@ func testParse(buf []byte) (toks []Token) {
 
 	return toks
 }
+
+// lexTests are regression cases for TestLex. For cases with checkPos set, the
+// token positions are compared too.
+var lexTests = []struct {
+	name     string
+	src      string
+	checkPos bool
+	want     []Token
+}{
+	{"empty", "", true, []Token{{Name: EOF, Pos: Position{0, 1, 1}}}},
+	{"identifiers", "foo _bar $baz x1", true, []Token{
+		{Name: IDENTIFIER, Val: "foo", Pos: Position{0, 1, 1}},
+		{Name: IDENTIFIER, Val: "_bar", Pos: Position{4, 1, 5}},
+		{Name: IDENTIFIER, Val: "$baz", Pos: Position{9, 1, 10}},
+		{Name: IDENTIFIER, Val: "x1", Pos: Position{14, 1, 15}},
+		{Name: EOF, Pos: Position{16, 1, 17}},
+	}},
+	{"numbers", "42 3.14 .5 1e10 0x1F 0b101 1_000", false, []Token{
+		{Name: NUMBER, Val: "42"},
+		{Name: NUMBER, Val: "3.14"},
+		{Name: NUMBER, Val: ".5"},
+		{Name: NUMBER, Val: "1e10"},
+		{Name: NUMBER, Val: "0x1F"},
+		{Name: NUMBER, Val: "0b101"},
+		{Name: NUMBER, Val: "1_000"},
+		{Name: EOF},
+	}},
+	{"operators", "a+=b->c<=d..e;", false, []Token{
+		{Name: IDENTIFIER, Val: "a"},
+		{Name: PLUS_EQ, Val: "+="},
+		{Name: IDENTIFIER, Val: "b"},
+		{Name: ARROW, Val: "->"},
+		{Name: IDENTIFIER, Val: "c"},
+		{Name: LE, Val: "<="},
+		{Name: IDENTIFIER, Val: "d"},
+		{Name: RANGE, Val: ".."},
+		{Name: IDENTIFIER, Val: "e"},
+		{Name: SEMI, Val: ";"},
+		{Name: EOF},
+	}},
+	{"quotes", "\"a\\\"b\" `raw` 'c'", true, []Token{
+		{Name: QUOTE, Val: "\"a\\\"b\"", Pos: Position{0, 1, 1}},
+		{Name: RAW_QUOTE, Val: "`raw`", Pos: Position{7, 1, 8}},
+		{Name: CHAR, Val: "'c'", Pos: Position{13, 1, 14}},
+		{Name: EOF, Pos: Position{16, 1, 17}},
+	}},
+	{"multibyte", "défé \"日本\" ü", true, []Token{
+		{Name: IDENTIFIER, Val: "défé", Pos: Position{0, 1, 1}},
+		{Name: QUOTE, Val: "\"日本\"", Pos: Position{7, 1, 6}},
+		{Name: IDENTIFIER, Val: "ü", Pos: Position{16, 1, 11}},
+		{Name: EOF, Pos: Position{18, 1, 12}},
+	}},
+	{"comments", "a // b\n/* c */", false, []Token{
+		{Name: IDENTIFIER, Val: "a"},
+		{Name: COMMENT, Val: "// b"},
+		{Name: COMMENT, Val: "/* c */"},
+		{Name: EOF},
+	}},
+	{"unexpected character", "a\n ^", true, []Token{
+		{Name: IDENTIFIER, Val: "a", Pos: Position{0, 1, 1}},
+		{Name: ERROR, Pos: Position{3, 2, 2}, Msg: "unexpected character '^'"},
+	}},
+	{"unterminated string", "x \"abc", true, []Token{
+		{Name: IDENTIFIER, Val: "x", Pos: Position{0, 1, 1}},
+		{Name: ERROR, Pos: Position{2, 1, 3}, Msg: "unterminated string literal"},
+	}},
+	// Runes past the end of opTable mustn't be looked up in it.
+	{"leading high rune", "本x+1", true, []Token{
+		{Name: IDENTIFIER, Val: "本x", Pos: Position{0, 1, 1}},
+		{Name: PLUS, Val: "+", Pos: Position{4, 1, 3}},
+		{Name: NUMBER, Val: "1", Pos: Position{5, 1, 4}},
+		{Name: EOF, Pos: Position{6, 1, 5}},
+	}},
+	{"rune past opTable", "\x7f", true, []Token{
+		{Name: ERROR, Pos: Position{0, 1, 1}, Msg: `unexpected character '\x7f'`},
+	}},
+	{"max rune", "\U0010FFFF", true, []Token{
+		{Name: ERROR, Pos: Position{0, 1, 1}, Msg: "unexpected character '\\U0010ffff'"},
+	}},
+}
+
+func TestLex(t *testing.T) {
+	for _, tt := range lexTests {
+		got := Lex([]byte(tt.src))
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %d tokens %v, want %d tokens %v", tt.name, len(got), got, len(tt.want), tt.want)
+			continue
+		}
+		for i, want := range tt.want {
+			equal := got[i].Equal(want)
+			if tt.checkPos {
+				equal = got[i].EqualPos(want)
+			}
+			if !equal || got[i].Msg != want.Msg {
+				t.Errorf("%s: token %d: got %v at %s (%q), want %v at %s (%q)", tt.name, i, got[i], got[i].Pos, got[i].Msg, want, want.Pos, want.Msg)
+			}
+		}
+	}
+}
+
+func TestLineCol(t *testing.T) {
+	toks := testParse([]byte("foo bar\n  \"本ä\" baz\r\nqux"))
+
+	expected := []struct {
+		val       string
+		line, col int
+	}{
+		{"foo", 1, 1},
+		{"bar", 1, 5},
+		{"\"本ä\"", 2, 3},
+		{"baz", 2, 8},
+		{"qux", 3, 1},
+		{"", 3, 4},
+	}
+
+	if len(toks) != len(expected) {
+		t.Fatal("expected", len(expected), "tokens, got", len(toks))
+	}
+	for i, e := range expected {
+		tok := toks[i]
+		if tok.Val != e.val || tok.Pos.Line != e.line || tok.Pos.Col != e.col {
+			t.Errorf("token %d: got %v at %s, want '%s' at %d:%d", i, tok, tok.Position(), e.val, e.line, e.col)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	src := []byte("a // line\nb /* block\n   comment */ c /* open")
+
+	toks := testParse(src)
+	expected := []Token{
+		{Name: IDENTIFIER, Val: "a", Pos: Position{0, 1, 1}},
+		{Name: COMMENT, Val: "// line", Pos: Position{2, 1, 3}},
+		{Name: IDENTIFIER, Val: "b", Pos: Position{10, 2, 1}},
+		{Name: COMMENT, Val: "/* block\n   comment */", Pos: Position{12, 2, 3}},
+		{Name: IDENTIFIER, Val: "c", Pos: Position{35, 3, 15}},
+		{Name: ERROR, Val: "", Pos: Position{37, 3, 17}, Msg: "unterminated block comment"},
+	}
+	if len(toks) < len(expected) {
+		t.Fatal("expected", len(expected), "tokens, got", len(toks))
+	}
+	for i, e := range expected {
+		if !reflect.DeepEqual(toks[i], e) {
+			t.Errorf("token %d: got %v, want %v", i, toks[i], e)
+		}
+	}
+
+	lex := NewLexerWithOptions(src[:35], Options{SkipComments: true})
+	for _, want := range []string{"a", "b", ""} {
+		if tok := lex.NextToken(); tok.Val != want || tok.Name == COMMENT {
+			t.Errorf("got %v, want '%s'", tok, want)
+		}
+	}
+	// A line comment ends before "\r\n" or a lone '\r', as before '\n'.
+	for _, opts := range []Options{{}, {SignificantNewlines: true}, {LineCommentPrefixes: []string{"#"}}} {
+		toks := NewLexerWithOptions([]byte("a // one\r\nb # two\rc"), opts).Tokens()
+		var comments []string
+		for _, tok := range toks {
+			if tok.Name == COMMENT {
+				comments = append(comments, tok.Val)
+			}
+		}
+		want := []string{"// one"}
+		if opts.LineCommentPrefixes != nil {
+			want = []string{"# two"}
+		}
+		if !reflect.DeepEqual(comments, want) {
+			t.Errorf("%v: got comments %q, want %q", opts.LineCommentPrefixes, comments, want)
+		}
+	}
+}
+
+func TestFloats(t *testing.T) {
+	var tests = []struct {
+		input string
+		vals  []string
+	}{
+		{"3.14", []string{"3.14"}},
+		{".5", []string{".5"}},
+		{"5.", []string{"5."}},
+		{"42", []string{"42"}},
+		{"3.14.15", []string{"3.14", ".15"}},
+		{"1..2", []string{"1", "..", "2"}},
+		{"a.b", []string{"a", ".", "b"}},
+	}
+
+	for _, tt := range tests {
+		toks := testParse([]byte(tt.input))
+		if len(toks) != len(tt.vals)+1 {
+			t.Errorf("%q: expected %d tokens, got %v", tt.input, len(tt.vals)+1, toks)
+			continue
+		}
+		for i, val := range tt.vals {
+			if toks[i].Val != val {
+				t.Errorf("%q: token %d: got %v, want '%s'", tt.input, i, toks[i], val)
+			}
+		}
+	}
+}
+
+func TestIsValidNumber(t *testing.T) {
+	for _, val := range []string{
+		"42", "3.14", ".5", "5.", "1e10", "2.5E-3", "1_000", "-3", "+0.5",
+		"0x1F", "0b101", "0o17", "-0x10", "99999999999999999999", "0xFFFFFFFFFFFFFFFFFF", "1e400",
+		"inf", "NaN", "-Inf", "INF", "infinity", "-Infinity",
+	} {
+		if tok := (Token{Name: NUMBER, Val: val}); !tok.IsValidNumber() {
+			t.Errorf("%q isn't valid", val)
+		}
+	}
+	for _, val := range []string{
+		"", "3.14.15", "1e", "1e+", "0x", "0b102", "0xG", "0x1p-2", "--1", "1-", "1 2",
+		"-nan", "+NaN", "1__0", "1_", "_1", "0x_1", "0b1_", "1._5", "1_.5", "1e5_0", "1e_5",
+	} {
+		if tok := (Token{Name: NUMBER, Val: val}); tok.IsValidNumber() {
+			t.Errorf("%q is valid", val)
+		}
+	}
+	if tok := (Token{Name: IDENTIFIER, Val: "42"}); tok.IsValidNumber() {
+		t.Error("IDENTIFIER 42 is a valid number")
+	}
+
+	// Every NUMBER token the lexer produces is valid.
+	for _, tok := range Lex([]byte("0 1.5e3 0x_1F 1_000 .5 5. 0b1 1__0 1_ 1._5 1e5_0 0xF_F 2.5_0E-1_0")) {
+		if tok.Name == NUMBER && !tok.IsValidNumber() {
+			t.Errorf("lexed %v isn't valid", tok)
+		}
+	}
+}
+
+func TestFloatKeywords(t *testing.T) {
+	src := []byte("inf Inf nan NaN infinity x")
+	toks := NewLexerWithOptions(src, Options{FloatKeywords: true}).Tokens()
+	want := []TokenName{NUMBER, NUMBER, NUMBER, NUMBER, IDENTIFIER, IDENTIFIER, EOF}
+	if len(toks) != len(want) {
+		t.Fatalf("got %v, want %v", toks, want)
+	}
+	for i, tok := range toks {
+		if tok.Name != want[i] {
+			t.Errorf("token %d: got %v, want %v", i, tok, want[i])
+		}
+	}
+	for i, tok := range toks[:4] {
+		f, err := tok.Float()
+		if isInf := i < 2; err != nil || isInf && !math.IsInf(f, 1) || !isInf && !math.IsNaN(f) {
+			t.Errorf("%v: Float() = %g, %v", tok, f, err)
+		}
+		if !tok.IsValidNumber() {
+			t.Errorf("%v isn't a valid number", tok)
+		}
+	}
+
+	// With SignedNumbers, an infinity can be signed. A signed NaN, which
+	// strconv rejects, stays an operator.
+	toks = NewLexerWithOptions([]byte("-inf, +Inf, -nan, -info"), Options{FloatKeywords: true, SignedNumbers: true}).Tokens()
+	want = []TokenName{NUMBER, COMMA, NUMBER, COMMA, MINUS, NUMBER, COMMA, MINUS, IDENTIFIER, EOF}
+	if len(toks) != len(want) || toks[0].Val != "-inf" || toks[2].Val != "+Inf" {
+		t.Fatalf("signed keywords: got %v", toks)
+	}
+	for i, tok := range toks {
+		if tok.Name != want[i] {
+			t.Errorf("signed keywords: token %d: got %v, want %v", i, tok, want[i])
+		}
+	}
+	for i, tok := range []Token{toks[0], toks[2]} {
+		f, err := tok.Float()
+		if err != nil || !math.IsInf(f, 2*i-1) || !tok.IsValidNumber() {
+			t.Errorf("%v: Float() = %g, %v", tok, f, err)
+		}
+	}
+
+	// Other spellings can be added as keywords.
+	lex := NewLexerWithOptions([]byte("Infinity"), Options{Keywords: map[string]TokenName{"Infinity": NUMBER}})
+	if tok := lex.NextToken(); !tok.IsValidNumber() {
+		t.Errorf("Infinity isn't a valid number")
+	} else if f, err := tok.Float(); err != nil || !math.IsInf(f, 1) {
+		t.Errorf("Infinity: Float() = %g, %v", f, err)
+	}
+
+	// The feature is off by default.
+	for _, tok := range Lex(src[:len(src)-2]) {
+		if tok.Name != IDENTIFIER && tok.Name != EOF {
+			t.Errorf("without FloatKeywords got %v", tok)
+		}
+	}
+}
+
+func TestBasePrefixes(t *testing.T) {
+	var tests = []struct {
+		input string
+		vals  []string
+		n     int64
+	}{
+		{"0x1F", []string{"0x1F"}, 31},
+		{"0XaB", []string{"0XaB"}, 171},
+		{"0o17", []string{"0o17"}, 15},
+		{"0b1010", []string{"0b1010"}, 10},
+		{"017", []string{"017"}, 17},
+		{"0", []string{"0"}, 0},
+		{"0xG", []string{"0", "xG"}, 0},
+		{"0x1G", []string{"0x1", "G"}, 1},
+		{"0b12", []string{"0b1", "2"}, 1},
+	}
+
+	for _, tt := range tests {
+		toks := testParse([]byte(tt.input))
+		if len(toks) != len(tt.vals)+1 {
+			t.Errorf("%q: expected %d tokens, got %v", tt.input, len(tt.vals)+1, toks)
+			continue
+		}
+		for i, val := range tt.vals {
+			if toks[i].Val != val {
+				t.Errorf("%q: token %d: got %v, want '%s'", tt.input, i, toks[i], val)
+			}
+		}
+		if n, err := toks[0].Int(); err != nil || n != tt.n {
+			t.Errorf("%q: Int() = %d, %v; want %d", tt.input, n, err, tt.n)
+		}
+	}
+}
+
+func TestExponents(t *testing.T) {
+	var tests = []struct {
+		input string
+		vals  []string
+	}{
+		{"1e10", []string{"1e10"}},
+		{"2.5E-3", []string{"2.5E-3"}},
+		{"6.02e+23", []string{"6.02e+23"}},
+		{".5e1", []string{".5e1"}},
+		{"1e", []string{"1", "e"}},
+		{"1e+", []string{"1", "e", "+"}},
+		{"1e-x", []string{"1", "e", "-", "x"}},
+	}
+
+	for _, tt := range tests {
+		toks := testParse([]byte(tt.input))
+		if len(toks) != len(tt.vals)+1 {
+			t.Errorf("%q: expected %d tokens, got %v", tt.input, len(tt.vals)+1, toks)
+			continue
+		}
+		for i, val := range tt.vals {
+			if toks[i].Val != val {
+				t.Errorf("%q: token %d: got %v, want '%s'", tt.input, i, toks[i], val)
+			}
+		}
+		if toks[0].Name != NUMBER {
+			t.Errorf("%q: got %v, want a NUMBER", tt.input, toks[0])
+		} else if _, err := strconv.ParseFloat(toks[0].Val, 64); err != nil {
+			t.Errorf("%q: %v", tt.input, err)
+		}
+	}
+}
+
+func TestQuoteEscapes(t *testing.T) {
+	var tests = []struct {
+		input   string
+		decoded string
+	}{
+		{`"abc"`, "abc"},
+		{`"a\"b"`, `a"b`},
+		{`"a\\"`, `a\`},
+		{`"\n\t\r"`, "\n\t\r"},
+		{`"\u00e4"`, "ä"},
+		{`"äx"`, "äx"},
+		{`"本ä"`, "本ä"},
+	}
+
+	for _, tt := range tests {
+		toks := testParse([]byte(tt.input))
+		if len(toks) != 2 || toks[0].Name != QUOTE || toks[0].Val != tt.input {
+			t.Errorf("%s: got %v, want a single QUOTE", tt.input, toks)
+			continue
+		}
+		if s, err := toks[0].Unquote(); err != nil || s != tt.decoded {
+			t.Errorf("%s: Unquote() = %q, %v; want %q", tt.input, s, err, tt.decoded)
+		}
+	}
+
+	// An invalid escape is reported at its backslash, and the rest of the
+	// string is skipped.
+	toks := testParse([]byte(`"ab\qc" d`))
+	if len(toks) != 3 || toks[0].Name != ERROR || toks[0].Pos.Offset != 3 || toks[1].Val != "d" {
+		t.Errorf("got %v, want ERROR at 3 followed by 'd'", toks)
+	}
+	toks = testParse([]byte(`"\u12" d`))
+	if len(toks) != 3 || toks[0].Name != ERROR || toks[0].Pos.Offset != 1 {
+		t.Errorf("got %v, want ERROR at 1", toks)
+	}
+}
+
+func TestErrorMessages(t *testing.T) {
+	var tests = []struct {
+		input string
+		msg   string
+	}{
+		{`"abc`, "unterminated string literal"},
+		{`"a\qc"`, "invalid escape sequence"},
+		{"/* abc", "unterminated block comment"},
+		{"^", "unexpected character '^'"},
+		{"`abc", "unterminated raw string literal"},
+		{"\xff", "invalid UTF-8"},
+		{"\x80", "invalid UTF-8"},
+	}
+
+	for _, tt := range tests {
+		tok := NewLexer([]byte(tt.input)).NextToken()
+		if tok.Name != ERROR || tok.Msg != tt.msg {
+			t.Errorf("%q: got %v with message %q, want ERROR with %q", tt.input, tok, tok.Msg, tt.msg)
+		}
+	}
+}
+
+func TestPeekToken(t *testing.T) {
+	lex := NewLexer([]byte("foo 42"))
+
+	for _, want := range []string{"foo", "42", ""} {
+		peeked := lex.PeekToken()
+		if again := lex.PeekToken(); !reflect.DeepEqual(again, peeked) {
+			t.Errorf("PeekToken changed from %v to %v", peeked, again)
+		}
+		if tok := lex.NextToken(); !reflect.DeepEqual(tok, peeked) || tok.Val != want {
+			t.Errorf("NextToken = %v, want peeked %v with value '%s'", tok, peeked, want)
+		}
+	}
+
+	// Peeking at EOF is idempotent.
+	for i := 0; i < 3; i++ {
+		if tok := lex.PeekToken(); tok.Name != EOF || tok.Pos.Offset != 6 {
+			t.Errorf("PeekToken at end = %v, want EOF at 6", tok)
+		}
+		if tok := lex.NextToken(); tok.Name != EOF || tok.Pos.Offset != 6 {
+			t.Errorf("NextToken at end = %v, want EOF at 6", tok)
+		}
+	}
+}
+
+func TestAcceptIf(t *testing.T) {
+	lex := NewLexer([]byte("foo ( 42"))
+
+	if tok, ok := lex.AcceptIf(IDENTIFIER); !ok || tok.Val != "foo" {
+		t.Errorf("AcceptIf(IDENTIFIER) = %v, %v, want foo, true", tok, ok)
+	}
+	if tok, ok := lex.AcceptIf(NUMBER); ok {
+		t.Errorf("AcceptIf(NUMBER) = %v, true, want a miss", tok)
+	}
+	if tok := lex.NextToken(); tok.Name != L_PAREN {
+		t.Errorf("NextToken after a miss = %v, want L_PAREN", tok)
+	}
+	if tok, ok := lex.AcceptIf(NUMBER); !ok || tok.Val != "42" {
+		t.Errorf("AcceptIf(NUMBER) = %v, %v, want 42, true", tok, ok)
+	}
+	if _, ok := lex.AcceptIf(EOF); !ok {
+		t.Error("AcceptIf(EOF) missed at end of input")
+	}
+}
+
+func TestLineText(t *testing.T) {
+	src := "foo\n  bär ^ baz\nlast"
+	lex := NewLexer([]byte(src))
+	tests := []struct {
+		pos  int
+		line string
+		col  int
+	}{
+		{0, "foo", 1},
+		{3, "foo", 4},
+		{4, "  bär ^ baz", 1},
+		{strings.Index(src, "^"), "  bär ^ baz", 7},
+		{len(src), "last", 5},
+		{-1, "", 0},
+		{len(src) + 1, "", 0},
+	}
+	for _, tt := range tests {
+		if line, col := lex.LineText(tt.pos); line != tt.line || col != tt.col {
+			t.Errorf("LineText(%d) = %q, %d, want %q, %d", tt.pos, line, col, tt.line, tt.col)
+		}
+	}
+
+	var tok Token
+	for tok = lex.NextToken(); tok.Name != ERROR; tok = lex.NextToken() {
+	}
+	if _, col := lex.LineText(tok.Pos.Offset); col != tok.Pos.Col {
+		t.Errorf("LineText column %d doesn't match ERROR token column %d", col, tok.Pos.Col)
+	}
+}
+
+func TestContext(t *testing.T) {
+	src := "ab本äcd"
+	lex := NewLexer([]byte(src))
+	tests := []struct {
+		pos, radius int
+		want        string
+	}{
+		{2, 1, "b本"},
+		{2, 2, "ab本ä"},
+		{3, 1, "b本"}, // within 本
+		{4, 1, "b本"},
+		{5, 1, "本ä"},
+		{6, 1, "本ä"}, // within ä
+		{7, 2, "本äcd"},
+		{0, 3, "ab本"},
+		{len(src), 2, "cd"},
+		{-5, 1, "a"},
+		{len(src) + 5, 1, "d"},
+		{5, 0, ""},
+		{5, -1, ""},
+		{5, 10, src},
+	}
+	for _, tt := range tests {
+		got := lex.Context(tt.pos, tt.radius)
+		if got != tt.want {
+			t.Errorf("Context(%d, %d) = %q, want %q", tt.pos, tt.radius, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("Context(%d, %d) = %q splits a rune", tt.pos, tt.radius, got)
+		}
+	}
+
+	// A radius of 0 or less gives nothing in either encoding.
+	for _, enc := range []Encoding{UTF8, Latin1} {
+		lex := NewLexerWithOptions([]byte("ab\xe4cd"), Options{Encoding: enc})
+		for _, radius := range []int{0, -1, -100} {
+			if got := lex.Context(2, radius); got != "" {
+				t.Errorf("encoding %d: Context(2, %d) = %q, want \"\"", enc, radius, got)
+			}
+		}
+	}
+}
+
+func TestTokenEqual(t *testing.T) {
+	a := Token{Name: IDENTIFIER, Val: "foo"}
+	tests := []struct {
+		b            Token
+		equal, eqPos bool
+	}{
+		{Token{Name: IDENTIFIER, Val: "foo"}, true, true},
+		{Token{Name: IDENTIFIER, Val: "foo", Pos: Position{Offset: 7, Line: 2}}, true, false},
+		{Token{Name: IDENTIFIER, Bytes: []byte("foo")}, true, true},
+		{Token{Name: IDENTIFIER, Val: "bar"}, false, false},
+		{Token{Name: QUOTE, Val: "foo"}, false, false},
+	}
+	for _, tt := range tests {
+		if got := a.Equal(tt.b); got != tt.equal {
+			t.Errorf("%v.Equal(%v) = %v, want %v", a, tt.b, got, tt.equal)
+		}
+		if got := a.EqualPos(tt.b); got != tt.eqPos {
+			t.Errorf("%v.EqualPos(%v) = %v, want %v", a, tt.b, got, tt.eqPos)
+		}
+	}
+
+	toks := Lex([]byte("a + 1"))
+	want := []Token{{Name: IDENTIFIER, Val: "a"}, {Name: PLUS, Val: "+"}, {Name: NUMBER, Val: "1"}, {Name: EOF}}
+	if !TokensEqual(toks, want) {
+		t.Errorf("TokensEqual(%v, %v) = false", toks, want)
+	}
+	if TokensEqual(toks, want[:3]) {
+		t.Error("TokensEqual is true for slices of different lengths")
+	}
+}
+
+func TestLineMap(t *testing.T) {
+	src := []byte("ab\r\nçé x\r\n\n\tz ")
+	m := NewLineMap(src)
+	for _, tok := range Lex(src) {
+		if line, col := m.LineCol(tok.Pos.Offset); line != tok.Pos.Line || col != tok.Pos.Col {
+			t.Errorf("LineCol(%d) = %d:%d, want %s for %v", tok.Pos.Offset, line, col, tok.Position(), tok)
+		}
+	}
+	if line, col := m.LineCol(bytes.IndexByte(src, 'x')); line != 2 || col != 4 {
+		t.Errorf("LineCol of x = %d:%d, want 2:4", line, col)
+	}
+	if line, col := m.LineCol(-5); line != 1 || col != 1 {
+		t.Errorf("LineCol(-5) = %d:%d, want 1:1", line, col)
+	}
+	if line, col := m.LineCol(1000); line != 4 || col != 4 {
+		t.Errorf("LineCol(1000) = %d:%d, want 4:4", line, col)
+	}
+}
+
+func TestLineEndings(t *testing.T) {
+	tests := []struct {
+		src  string
+		line int
+	}{
+		{"a\rb", 2},
+		{"a\r\nb", 2},
+		{"a\n\rb", 3},
+		{"a\r\rb", 3},
+		{"a\r\n\r\nb", 3},
+		{"a // c\rb", 2},
+	}
+	for _, tt := range tests {
+		src := []byte(tt.src)
+		tokens := NewLexerWithOptions(src, Options{SkipComments: true}).Tokens()
+		if len(tokens) != 3 || tokens[1].Val != "b" {
+			t.Errorf("%q: got %v, want a, b and EOF", tt.src, tokens)
+			continue
+		}
+		if b := tokens[1]; b.Pos.Line != tt.line || b.Pos.Col != 1 {
+			t.Errorf("%q: b at %s, want %d:1", tt.src, b.Position(), tt.line)
+		}
+		if line, col := NewLineMap(src).LineCol(tokens[1].Pos.Offset); line != tt.line || col != 1 {
+			t.Errorf("%q: LineCol of b = %d:%d, want %d:1", tt.src, line, col, tt.line)
+		}
+		if line, _ := NewLexer(src).LineText(tokens[1].Pos.Offset); line != "b" {
+			t.Errorf("%q: LineText of b = %q, want %q", tt.src, line, "b")
+		}
+		rd := NewLexerReader(iotest.OneByteReader(bytes.NewReader(src))).Tokens()
+		if !reflect.DeepEqual(rd, NewLexer(src).Tokens()) {
+			t.Errorf("%q: reader lexer got %v", tt.src, rd)
+		}
+	}
+}
+
+func TestLineEndingOptions(t *testing.T) {
+	const src = "a:\n  b \n\n  c\n d\ne"
+	tests := []Options{
+		{SignificantNewlines: true},
+		{SignificantNewlines: true, EmitWhitespace: true},
+		{Indentation: true},
+		{Indentation: true, EmitWhitespace: true},
+		{SignificantNewlines: true, AutoSemi: true},
+	}
+	for i, opts := range tests {
+		want := NewLexerWithOptions([]byte(src), opts).Tokens()
+		for _, eol := range []string{"\r\n", "\r"} {
+			src := []byte(strings.ReplaceAll(src, "\n", eol))
+			got := NewLexerWithOptions(src, opts).Tokens()
+			if len(got) != len(want) {
+				t.Errorf("%d: %q: got %v, want %v", i, eol, got, want)
+				continue
+			}
+			var text strings.Builder
+			for j, tok := range got {
+				if tok.Name != want[j].Name || tok.Pos.Line != want[j].Pos.Line || tok.Pos.Col != want[j].Pos.Col {
+					t.Errorf("%d: %q: token %d is %v at %s, want %v at %s", i, eol, j, tok, tok.Position(), want[j], want[j].Position())
+				}
+				if tok.Name == NEWLINE && tok.Val != eol {
+					t.Errorf("%d: %q: NEWLINE %q", i, eol, tok.Val)
+				}
+				text.WriteString(tok.Val)
+			}
+			if opts.EmitWhitespace && !opts.Indentation && text.String() != string(src) {
+				t.Errorf("%d: %q: tokens give %q", i, eol, text.String())
+			}
+			rd := NewLexerReaderWithOptions(iotest.OneByteReader(bytes.NewReader(src)), opts).Tokens()
+			if !reflect.DeepEqual(rd, got) {
+				t.Errorf("%d: %q: reader got %v, want %v", i, eol, rd, got)
+			}
+		}
+	}
+}
+
+func TestTabWidth(t *testing.T) {
+	src := []byte("\tx\n  \ty\n\t\tz ä\tw")
+	tests := []struct {
+		tabWidth int
+		cols     []int
+	}{
+		{0, []int{2, 4, 3, 5, 7}},
+		{1, []int{2, 4, 3, 5, 7}},
+		{4, []int{5, 5, 9, 11, 13}},
+		{8, []int{9, 9, 17, 19, 25}},
+	}
+	for _, tt := range tests {
+		lex := NewLexerWithOptions(src, Options{TabWidth: tt.tabWidth, TrackRuneOffsets: true})
+		m := NewLineMap(src)
+		m.TabWidth = tt.tabWidth
+		var cols []int
+		for _, tok := range lex.Tokens() {
+			if tok.Name == EOF {
+				break
+			}
+			cols = append(cols, tok.Pos.Col)
+			if _, col := m.LineCol(tok.Pos.Offset); col != tok.Pos.Col {
+				t.Errorf("TabWidth %d: LineCol of %v = %d, want %d", tt.tabWidth, tok, col, tok.Pos.Col)
+			}
+			if _, col := lex.LineText(tok.Pos.Offset); col != tok.Pos.Col {
+				t.Errorf("TabWidth %d: LineText column of %v = %d, want %d", tt.tabWidth, tok, col, tok.Pos.Col)
+			}
+			if want := utf8.RuneCount(src[:tok.Pos.Offset]); tok.RuneOffset != want {
+				t.Errorf("TabWidth %d: RuneOffset of %v = %d, want %d", tt.tabWidth, tok, tok.RuneOffset, want)
+			}
+		}
+		if !reflect.DeepEqual(cols, tt.cols) {
+			t.Errorf("TabWidth %d: got columns %v, want %v", tt.tabWidth, cols, tt.cols)
+		}
+	}
+}
+
+func TestUnread(t *testing.T) {
+	lex := NewLexer([]byte("a b c d"))
+
+	a, b := lex.NextToken(), lex.NextToken()
+	if err := lex.Unread(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := lex.Unread(a); err != nil {
+		t.Fatal(err)
+	}
+	if tok := lex.PeekToken(); !reflect.DeepEqual(tok, a) {
+		t.Errorf("PeekToken = %v, want %v", tok, a)
+	}
+	for _, want := range []string{"a", "b", "c"} {
+		if tok := lex.NextToken(); tok.Val != want {
+			t.Errorf("NextToken = %v, want '%s'", tok, want)
+		}
+	}
+
+	for i := 0; i < MaxPushback; i++ {
+		if err := lex.Unread(a); err != nil {
+			t.Fatalf("Unread #%d: %v", i+1, err)
+		}
+	}
+	if err := lex.Unread(a); err != ErrPushbackFull {
+		t.Errorf("Unread past MaxPushback = %v, want ErrPushbackFull", err)
+	}
+}
+
+func TestCompoundOperators(t *testing.T) {
+	var tests = []struct {
+		input string
+		names []TokenName
+	}{
+		{"==", []TokenName{EQ_EQ}},
+		{"!=", []TokenName{NOT_EQ}},
+		{"<=", []TokenName{LE}},
+		{">=", []TokenName{GE}},
+		{"&&", []TokenName{AND_AND}},
+		{"||", []TokenName{OR_OR}},
+		{"===", []TokenName{EQ_EQ, EQUALS}},
+		{"= =", []TokenName{EQUALS, EQUALS}},
+		{"!a", []TokenName{EXCLAMATION, IDENTIFIER}},
+		{"a<b", []TokenName{IDENTIFIER, L_ANG, IDENTIFIER}},
+		{"&|", []TokenName{AMPERSAND, PIPE}},
+		{":=", []TokenName{COLON_EQ}},
+		{"->", []TokenName{ARROW}},
+		{"+=", []TokenName{PLUS_EQ}},
+		{"-=", []TokenName{MINUS_EQ}},
+		{"::", []TokenName{COLON_COLON}},
+		{"a..b", []TokenName{IDENTIFIER, RANGE, IDENTIFIER}},
+		{"...", []TokenName{RANGE, PERIOD}},
+		{"a:b", []TokenName{IDENTIFIER, COLON, IDENTIFIER}},
+		{"a.b", []TokenName{IDENTIFIER, PERIOD, IDENTIFIER}},
+		{"-1", []TokenName{MINUS, NUMBER}},
+		{"-<", []TokenName{MINUS, L_ANG}},
+		{"<<", []TokenName{SHL}},
+		{">>", []TokenName{SHR}},
+		{">>>", []TokenName{SHR, R_ANG}},
+	}
+
+	for _, tt := range tests {
+		toks := testParse([]byte(tt.input))
+		if len(toks) != len(tt.names)+1 {
+			t.Errorf("%q: expected %d tokens, got %v", tt.input, len(tt.names)+1, toks)
+			continue
+		}
+		for i, name := range tt.names {
+			if toks[i].Name != name {
+				t.Errorf("%q: token %d: got %v, want %s", tt.input, i, toks[i], name)
+			}
+		}
+	}
+}
+
+func TestAtTilde(t *testing.T) {
+	var tests = []struct {
+		input string
+		want  []Token
+	}{
+		{"@foo", []Token{
+			{Name: AT, Val: "@", Pos: Position{0, 1, 1}},
+			{Name: IDENTIFIER, Val: "foo", Pos: Position{1, 1, 2}},
+			{Name: EOF, Pos: Position{4, 1, 5}},
+		}},
+		{"~x", []Token{
+			{Name: TILDE, Val: "~", Pos: Position{0, 1, 1}},
+			{Name: IDENTIFIER, Val: "x", Pos: Position{1, 1, 2}},
+			{Name: EOF, Pos: Position{2, 1, 3}},
+		}},
+		{"~@", []Token{
+			{Name: TILDE, Val: "~", Pos: Position{0, 1, 1}},
+			{Name: AT, Val: "@", Pos: Position{1, 1, 2}},
+			{Name: EOF, Pos: Position{2, 1, 3}},
+		}},
+	}
+	for _, tt := range tests {
+		if got := Lex([]byte(tt.input)); !TokensEqual(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestDoubledOperators(t *testing.T) {
+	const POW, CARET_CARET TokenName = 1000, 1001
+	doubled := map[rune]TokenName{'<': SHL, '*': POW, '^': CARET_CARET, '>': SHR}
+	tests := []struct {
+		input string
+		names []TokenName
+	}{
+		{"<<", []TokenName{SHL, EOF}},
+		{"<", []TokenName{L_ANG, EOF}},
+		{"<<<", []TokenName{SHL, L_ANG, EOF}},
+		{"<<<<", []TokenName{SHL, SHL, EOF}},
+		{"< <", []TokenName{L_ANG, L_ANG, EOF}},
+		{"<=", []TokenName{LE, EOF}},
+		{"a**2*b", []TokenName{IDENTIFIER, POW, NUMBER, MULTIPLY, IDENTIFIER, EOF}},
+		{"a^^b", []TokenName{IDENTIFIER, CARET_CARET, IDENTIFIER, EOF}},
+		{"a&&b", []TokenName{IDENTIFIER, AND_AND, IDENTIFIER, EOF}},
+	}
+	for _, tt := range tests {
+		var got []TokenName
+		for _, tok := range NewLexerWithOptions([]byte(tt.input), Options{DoubledOperators: doubled}).Tokens() {
+			got = append(got, tok.Name)
+		}
+		if !reflect.DeepEqual(got, tt.names) {
+			t.Errorf("%q: got %v, want %v", tt.input, got, tt.names)
+		}
+	}
+
+	// Angle brackets are still closed one at a time.
+	lex := NewLexerWithOptions([]byte(">>"), Options{DoubledOperators: doubled})
+	lex.PushMode(ModeAngleBrackets)
+	for _, want := range []TokenName{R_ANG, R_ANG} {
+		if tok := lex.NextToken(); tok.Name != want {
+			t.Errorf("got %v, want %s", tok, want)
+		}
+	}
+
+	// A single '*' at the end of the input might still become "**".
+	lex = NewLexerWithOptions([]byte("a *"), Options{DoubledOperators: doubled, MarkTruncated: true})
+	if toks := lex.Tokens(); !toks[1].Truncated {
+		t.Errorf("%v isn't marked truncated", toks[1])
+	}
+}
+
+func TestKeywords(t *testing.T) {
+	src := []byte("class Foo; def bar : Foo; defx")
+
+	lex := NewLexerWithKeywords(src, TableGenKeywords)
+	for _, want := range []TokenName{CLASS, IDENTIFIER, SEMI, DEF, IDENTIFIER, COLON, IDENTIFIER, SEMI, IDENTIFIER, EOF} {
+		if tok := lex.NextToken(); tok.Name != want {
+			t.Errorf("got %v, want %s", tok, want)
+		}
+	}
+
+	// Without a keyword table, keywords are plain identifiers.
+	if tok := NewLexer(src).NextToken(); tok.Name != IDENTIFIER {
+		t.Errorf("got %v, want IDENTIFIER", tok)
+	}
+}
+
+func TestCaseInsensitiveKeywords(t *testing.T) {
+	const SELECT, FROM = DEF, IN
+	keywords := map[string]TokenName{"select": SELECT, "from": FROM}
+	src := []byte("SELECT Select select FROM selected Selected")
+	lex := NewLexerWithOptions(src, Options{Keywords: keywords, CaseInsensitiveKeywords: true})
+	want := []Token{
+		{Name: SELECT, Val: "SELECT"},
+		{Name: SELECT, Val: "Select"},
+		{Name: SELECT, Val: "select"},
+		{Name: FROM, Val: "FROM"},
+		{Name: IDENTIFIER, Val: "selected"},
+		{Name: IDENTIFIER, Val: "Selected"},
+		{Name: EOF},
+	}
+	if got := lex.Tokens(); !TokensEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Without the option, keywords are case-sensitive.
+	lex = NewLexerWithKeywords(src, keywords)
+	if tok := lex.NextToken(); tok.Name != IDENTIFIER {
+		t.Errorf("got %v, want IDENTIFIER", tok)
+	}
+}
+
+func TestNewLexerWithOps(t *testing.T) {
+	const DOLLAR, SECTION TokenName = 1000, 1001
+	extra := map[rune]TokenName{'$': DOLLAR, '§': SECTION, '#': NONE, '=': COLON}
+	lex := NewLexerWithOps([]byte("$foo § == #"), extra)
+	want := []Token{
+		{Name: DOLLAR, Val: "$"},
+		{Name: IDENTIFIER, Val: "foo"},
+		{Name: SECTION, Val: "§"},
+		{Name: COLON, Val: "="},
+		{Name: COLON, Val: "="},
+		{Name: ERROR},
+	}
+	got := lex.Tokens()
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i].Name != want[i].Name || want[i].Val != "" && got[i].Val != want[i].Val {
+			t.Errorf("token %d: got %v %q, want %v %q", i, got[i].Name, got[i].Val, want[i].Name, want[i].Val)
+		}
+	}
+
+	// Without the extension, '$' starts an identifier and '§' is an error.
+	lex = NewLexer([]byte("$foo §"))
+	for _, want := range []TokenName{IDENTIFIER, ERROR} {
+		if tok := lex.NextToken(); tok.Name != want {
+			t.Errorf("got %v, want %s", tok, want)
+		}
+	}
+}
+
+func TestModes(t *testing.T) {
+	names := func(tokens []Token) []TokenName {
+		var names []TokenName
+		for _, tok := range tokens {
+			names = append(names, tok.Name)
+		}
+		return names
+	}
+	src := []byte("a >> b >= c")
+	if got, want := names(Lex(src)), []TokenName{IDENTIFIER, SHR, IDENTIFIER, GE, IDENTIFIER, EOF}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ModeDefault: got %v, want %v", got, want)
+	}
+	lex := NewLexer(src)
+	lex.PushMode(ModeAngleBrackets)
+	if got, want := names(lex.Tokens()), []TokenName{IDENTIFIER, R_ANG, R_ANG, IDENTIFIER, R_ANG, EQUALS, IDENTIFIER, EOF}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ModeAngleBrackets: got %v, want %v", got, want)
+	}
+
+	// A parser pushes the mode for each '<' and pops it at the matching '>'.
+	lex = NewLexer([]byte("list<list<int>> x = y >> 1 << 2"))
+	var got []TokenName
+	for tok := lex.NextToken(); tok.Name != EOF; tok = lex.NextToken() {
+		switch tok.Name {
+		case L_ANG:
+			lex.PushMode(ModeAngleBrackets)
+		case R_ANG:
+			lex.PopMode()
+		}
+		got = append(got, tok.Name)
+	}
+	want := []TokenName{IDENTIFIER, L_ANG, IDENTIFIER, L_ANG, IDENTIFIER, R_ANG, R_ANG, IDENTIFIER, EQUALS, IDENTIFIER, SHR, NUMBER, SHL, NUMBER}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if m := lex.Mode(); m != ModeDefault {
+		t.Errorf("Mode() = %v after popping all modes, want ModeDefault", m)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("PopMode with an empty mode stack didn't panic")
+		}
+	}()
+	lex.PopMode()
+}
+
+func TestTokens(t *testing.T) {
+	toks := Lex([]byte("a + 1"))
+	if len(toks) != 4 || toks[0].Val != "a" || toks[1].Name != PLUS || toks[3].Name != EOF {
+		t.Errorf("Lex = %v, want a, +, 1, EOF", toks)
+	}
+
+	lex := NewLexer([]byte(`a "b`))
+	toks = lex.Tokens()
+	if len(toks) != 2 || toks[1].Name != ERROR {
+		t.Errorf("Tokens = %v, want a followed by ERROR", toks)
+	}
+	if toks = lex.Tokens(); len(toks) != 0 {
+		t.Errorf("second Tokens = %v, want empty", toks)
+	}
+}
+
+func TestLexerReader(t *testing.T) {
+	var b bytes.Buffer
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&b, "def x%d : \"本ä %d\" /* c\n */ %d.5e3 // end\n", i, i, i)
+	}
+	src := b.Bytes()
+
+	want := Lex(src)
+	for _, r := range []io.Reader{bytes.NewReader(src), iotest.OneByteReader(bytes.NewReader(src))} {
+		got := NewLexerReader(r).Tokens()
+		if len(got) != len(want) {
+			t.Fatalf("expected %d tokens, got %d", len(want), len(got))
+		}
+		for i := range want {
+			if !reflect.DeepEqual(got[i], want[i]) {
+				t.Fatalf("token %d: got %v, want %v", i, got[i], want[i])
+			}
+		}
+	}
+
+	// Read errors are reported as ERROR tokens.
+	toks := NewLexerReader(iotest.TimeoutReader(bytes.NewReader([]byte("abc")))).Tokens()
+	if len(toks) != 2 || toks[1].Name != ERROR || toks[1].Msg != iotest.ErrTimeout.Error() {
+		t.Errorf("got %v, want 'abc' followed by ERROR", toks)
+	}
+
+	// Options apply as for NewLexerWithOptions, including Indentation
+	// implying SignificantNewlines.
+	src = []byte("a:\n  b // c\n")
+	opts := Options{Indentation: true, SkipComments: true}
+	want = NewLexerWithOptions(src, opts).Tokens()
+	if got := NewLexerReaderWithOptions(iotest.OneByteReader(bytes.NewReader(src)), opts).Tokens(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if !containsName(want, INDENT) || !containsName(want, NEWLINE) {
+		t.Errorf("%v has no INDENT or NEWLINE", want)
+	}
+}
+
+func containsName(toks []Token, name TokenName) bool {
+	for _, tok := range toks {
+		if tok.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSplitTokens(t *testing.T) {
+	src := "def x = \"a b\" // c\n  foo += 1.5e3 /* d */ `e f`  "
+	var want []string
+	for _, tok := range Lex([]byte(src)) {
+		if tok.Name != EOF {
+			want = append(want, tok.Val)
+		}
+	}
+	for _, r := range []io.Reader{strings.NewReader(src), iotest.OneByteReader(strings.NewReader(src))} {
+		sc := bufio.NewScanner(r)
+		sc.Split(SplitTokens)
+		var got []string
+		for sc.Scan() {
+			got = append(got, sc.Text())
+		}
+		if err := sc.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got tokens %q, want %q", got, want)
+		}
+	}
+
+	sc := bufio.NewScanner(strings.NewReader("a \"b"))
+	sc.Split(SplitTokens)
+	for sc.Scan() {
+	}
+	if err := sc.Err(); err == nil || err.Error() != "unterminated string literal" {
+		t.Errorf("Err() = %v, want unterminated string literal", err)
+	}
+}
+
+func TestClone(t *testing.T) {
+	lex := NewLexerWithOptions([]byte("a\n  b c\n    d\ne"), Options{Indentation: true})
+	for i := 0; i < 4; i++ {
+		lex.NextToken()
+	}
+	lex.PeekToken()
+	want := lex.Clone().Tokens()
+
+	c := lex.Clone()
+	for c.NextToken().Name != EOF {
+	}
+	if c.NextToken().Name != EOF {
+		t.Error("clone didn't stay at EOF")
+	}
+	if got := lex.Tokens(); !reflect.DeepEqual(got, want) {
+		t.Errorf("original after advancing the clone: got %v, want %v", got, want)
+	}
+	if len(want) != 9 || want[0].Val != "c" {
+		t.Errorf("unexpected remaining tokens %v", want)
+	}
+}
+
+func TestMaxTokenLen(t *testing.T) {
+	opts := Options{MaxTokenLen: 8}
+	lex := NewLexerWithOptions([]byte("short\n\n\n\n\n\n\n\n\n\n  waytoolong+x \"a b c d e f\" y"), opts)
+	var toks []Token
+	var got []string
+	for tok := lex.NextToken(); ; tok = lex.NextToken() {
+		toks = append(toks, tok)
+		if tok.Name == EOF {
+			break
+		}
+		if tok.Name == ERROR {
+			got = append(got, tok.Msg)
+		} else {
+			got = append(got, tok.Val)
+		}
+	}
+	if want := []string{"short", "token too long", "token too long", "y"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if toks[1].Pos.Offset != 17 {
+		t.Errorf("error at %d, want 17", toks[1].Pos.Offset)
+	}
+
+	// A huge unterminated string read from a reader must not be buffered
+	// whole.
+	const n = 10 << 20
+	r := io.MultiReader(strings.NewReader(`x "`), iotest.OneByteReader(strings.NewReader(strings.Repeat("a", 1000))),
+		io.LimitReader(repeatReader('a'), n), strings.NewReader(" foo"))
+	lex = NewLexerReaderWithOptions(r, Options{MaxTokenLen: 1 << 10})
+	want := []Token{
+		{Name: IDENTIFIER, Val: "x", Pos: Position{0, 1, 1}},
+		{Name: ERROR, Pos: Position{2, 1, 3}, Msg: "token too long"},
+		{Name: IDENTIFIER, Val: "foo", Pos: Position{n + 1004, 1, n + 1005}},
+		{Name: EOF, Pos: Position{n + 1007, 1, n + 1008}},
+	}
+	for i, w := range want {
+		if tok := lex.NextToken(); !reflect.DeepEqual(tok, w) {
+			t.Errorf("token %d: got %v, want %v", i, tok, w)
+		}
+		if c := cap(lex.buf); c > 64<<10 {
+			t.Fatalf("token %d: buffer grew to %d bytes", i, c)
+		}
+	}
+}
+
+// repeatReader is an endless stream of a single byte.
+type repeatReader byte
+
+func (r repeatReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(r)
+	}
+	return len(p), nil
+}
+
+func TestEmitWhitespace(t *testing.T) {
+	src := "  class Foo {\r\n\tint x = 1; // one\n\n /* two */\n}\n\n"
+	for _, opts := range []Options{{EmitWhitespace: true}, {EmitWhitespace: true, SignificantNewlines: true}} {
+		var b strings.Builder
+		lex := NewLexerWithOptions([]byte(src), opts)
+		for tok := lex.NextToken(); tok.Name != EOF; tok = lex.NextToken() {
+			if tok.Name == ERROR {
+				t.Fatalf("%+v: unexpected error %s", opts, tok.Msg)
+			}
+			b.WriteString(tok.Val)
+		}
+		if b.String() != src {
+			t.Errorf("%+v: tokens concatenate to %q, want %q", opts, b.String(), src)
+		}
+	}
+
+	toks := NewLexerWithOptions([]byte("a \t\nb"), Options{EmitWhitespace: true}).Tokens()
+	want := []Token{
+		{Name: IDENTIFIER, Val: "a", Pos: Position{0, 1, 1}},
+		{Name: WHITESPACE, Val: " \t\n", Pos: Position{1, 1, 2}},
+		{Name: IDENTIFIER, Val: "b", Pos: Position{4, 2, 1}},
+		{Name: EOF, Pos: Position{5, 2, 2}},
+	}
+	if !reflect.DeepEqual(toks, want) {
+		t.Errorf("got %v, want %v", toks, want)
+	}
+}
+
+func TestNestedComments(t *testing.T) {
+	src := []byte("/* a /* b /* c */ d */ e */ x")
+	tests := []struct {
+		opts Options
+		want []string
+	}{
+		{Options{}, []string{"/* a /* b /* c */", "d", "*", "/", "e", "*", "/", "x", ""}},
+		{Options{NestedComments: true}, []string{string(src[:27]), "x", ""}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, tok := range NewLexerWithOptions(src, tt.opts).Tokens() {
+			got = append(got, tok.Val)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%+v: got %q, want %q", tt.opts, got, tt.want)
+		}
+	}
+
+	errs := []struct {
+		src, msg string
+	}{
+		{"/* a /* b */", "unterminated block comment (nesting depth 1)"},
+		{"/* a /* b /* c */", "unterminated block comment (nesting depth 2)"},
+	}
+	for _, tt := range errs {
+		toks := NewLexerWithOptions([]byte(tt.src), Options{NestedComments: true}).Tokens()
+		if tok := toks[len(toks)-1]; tok.Name != ERROR || tok.Msg != tt.msg || tok.Pos.Offset != 0 {
+			t.Errorf("%q: got %v (%s), want ERROR at 0 (%s)", tt.src, tok, tok.Msg, tt.msg)
+		}
+	}
+}
+
+func TestSkip(t *testing.T) {
+	src := []byte("a // one\n  b /* two */ c\n\n")
+	opts := Options{
+		EmitWhitespace:      true,
+		SignificantNewlines: true,
+		Skip:                map[TokenName]bool{COMMENT: true, WHITESPACE: true, EOF: true},
+	}
+	var got []string
+	for _, tok := range NewLexerWithOptions(src, opts).Tokens() {
+		if tok.Name == COMMENT || tok.Name == WHITESPACE {
+			t.Errorf("got skipped token %v", tok)
+		}
+		got = append(got, tok.Name.String()+" "+tok.Val)
+	}
+	want := []string{"IDENTIFIER a", "NEWLINE \n", "IDENTIFIER b", "IDENTIFIER c", "NEWLINE \n", "NEWLINE \n", "EOF "}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// With the same options but no Skip, the input can be reconstructed.
+	opts.Skip = nil
+	var b strings.Builder
+	for _, tok := range NewLexerWithOptions(src, opts).Tokens() {
+		b.WriteString(tok.Val)
+	}
+	if b.String() != string(src) {
+		t.Errorf("without Skip got %q, want %q", b.String(), src)
+	}
+
+	lex := NewLexerWithOptions([]byte("a ^"), Options{Skip: map[TokenName]bool{IDENTIFIER: true, ERROR: true}})
+	if tok := lex.NextToken(); tok.Name != ERROR {
+		t.Errorf("got %v, want ERROR", tok)
+	}
+}
+
+func TestNextN(t *testing.T) {
+	lex := NewLexer([]byte("a b c d e"))
+	var pages [][]string
+	for {
+		toks := lex.NextN(2)
+		var page []string
+		for _, tok := range toks {
+			page = append(page, tok.Name.String()+" "+tok.Val)
+		}
+		pages = append(pages, page)
+		if len(toks) < 2 {
+			break
+		}
+	}
+	want := [][]string{
+		{"IDENTIFIER a", "IDENTIFIER b"},
+		{"IDENTIFIER c", "IDENTIFIER d"},
+		{"IDENTIFIER e", "EOF "},
+		nil,
+	}
+	if !reflect.DeepEqual(pages, want) {
+		t.Errorf("got pages %q, want %q", pages, want)
+	}
+
+	toks := NewLexer([]byte("x y")).NextN(10)
+	if len(toks) != 3 || toks[2].Name != EOF {
+		t.Errorf("NextN(10) = %v, want x, y and EOF", toks)
+	}
+	toks = NewLexer([]byte("x ^ y")).NextN(10)
+	if len(toks) != 2 || toks[1].Name != ERROR {
+		t.Errorf("NextN(10) = %v, want x and ERROR", toks)
+	}
+	if toks := NewLexer([]byte("x")).NextN(0); len(toks) != 0 {
+		t.Errorf("NextN(0) = %v, want no tokens", toks)
+	}
+}
+
+func TestMarkTruncated(t *testing.T) {
+	tests := []struct {
+		src       string
+		truncated bool
+	}{
+		{"foo", true},
+		{"foo ", false},
+		{"x 42", true},
+		{"x 4.", true},
+		{"a -", true},
+		{"a (", false},
+		{`"foo"`, false},
+		{`""`, true},
+		{`"open`, true},
+		{"'a'", false},
+		{"`raw`", false},
+		{"// c", true},
+		{"/* c */", false},
+		{"/* c", true},
+		{"x\n", false},
+	}
+	for _, tt := range tests {
+		lex := NewLexerWithOptions([]byte(tt.src), Options{MarkTruncated: true, SignificantNewlines: true})
+		toks := lex.Tokens()
+		n := len(toks) - 1
+		if toks[n].Name == EOF {
+			n--
+		}
+		if last := toks[n]; last.Truncated != tt.truncated {
+			t.Errorf("%q: %v has Truncated %t, want %t", tt.src, last, last.Truncated, tt.truncated)
+		}
+		for _, tok := range toks[:n] {
+			if tok.Truncated {
+				t.Errorf("%q: %v is truncated", tt.src, tok)
+			}
+		}
+	}
+
+	// A token that ends before the input does is truncated too if the
+	// lexer's lookahead ran out: "1" in "1e" could become "1e5".
+	for _, src := range []string{"1e", "1e+", "0x", "1_", "a.", "1e z"} {
+		lex := NewLexerWithOptions([]byte(src), Options{MarkTruncated: true, DottedIdentifiers: true})
+		if tok := lex.NextToken(); tok.Truncated != (src != "1e z") {
+			t.Errorf("%q: %v has Truncated %t", src, tok, tok.Truncated)
+		}
+	}
+
+	if tok := NewLexer([]byte("foo")).NextToken(); tok.Truncated {
+		t.Errorf("%v truncated without MarkTruncated", tok)
+	}
+}
+
+func TestAtEOF(t *testing.T) {
+	lex := NewLexer([]byte("a b "))
+	for i, want := range []bool{false, false, true} {
+		tok := lex.NextToken()
+		if got := lex.AtEOF(); got != want {
+			t.Errorf("after %v (token %d): AtEOF() = %t, want %t", tok, i, got, want)
+		}
+	}
+	if lex := NewLexer(nil); !lex.AtEOF() {
+		t.Error("AtEOF() = false for empty input")
+	}
+}
+
+func parallelInput(n int) [][]byte {
+	bufs := make([][]byte, n)
+	for i := range bufs {
+		bufs[i] = []byte(fmt.Sprintf("def X%d : Inst<\"x%d\", [%d, 0x%X]>; // %d\n", i, i, i, i, i))
+	}
+	return bufs
+}
+
+// TestLexParallel is most useful with -race.
+func TestLexParallel(t *testing.T) {
+	bufs := parallelInput(200)
+	bufs[7] = nil
+	bufs[8] = []byte("a ^ b")
+	got := LexParallel(bufs)
+	if len(got) != len(bufs) {
+		t.Fatalf("got %d results for %d inputs", len(got), len(bufs))
+	}
+	for i, buf := range bufs {
+		if want := Lex(buf); !reflect.DeepEqual(got[i], want) {
+			t.Errorf("input %d: got %v, want %v", i, got[i], want)
+		}
+	}
+	if got := LexParallel(nil); len(got) != 0 {
+		t.Errorf("LexParallel(nil) = %v", got)
+	}
+}
+
+func BenchmarkLexSerial(b *testing.B) {
+	bufs := parallelInput(1000)
+	for i := 0; i < b.N; i++ {
+		for _, buf := range bufs {
+			Lex(buf)
+		}
+	}
+}
+
+func BenchmarkLexParallel(b *testing.B) {
+	bufs := parallelInput(1000)
+	for i := 0; i < b.N; i++ {
+		LexParallel(bufs)
+	}
+}
+
+func TestLatin1(t *testing.T) {
+	src := []byte("S\xfc\xdf = \"\xe4\" f\xfcr \xe4x\n\t\xff")
+	opts := Options{Encoding: Latin1, Keywords: map[string]TokenName{"für": FOREACH}}
+	lex := NewLexerWithOptions(src, opts)
+	want := []Token{
+		{Name: IDENTIFIER, Val: "Süß", Pos: Position{0, 1, 1}},
+		{Name: EQUALS, Val: "=", Pos: Position{4, 1, 5}},
+		{Name: QUOTE, Val: `"ä"`, Pos: Position{6, 1, 7}},
+		{Name: FOREACH, Val: "für", Pos: Position{10, 1, 11}},
+		{Name: IDENTIFIER, Val: "äx", Pos: Position{14, 1, 15}},
+		{Name: IDENTIFIER, Val: "ÿ", Pos: Position{18, 2, 2}},
+		{Name: EOF, Pos: Position{19, 2, 3}},
+	}
+	if got := lex.Tokens(); !TokensEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if s, err := want[2].Unquote(); err != nil || s != "ä" {
+		t.Errorf("Unquote() = %q, %v, want \"ä\"", s, err)
+	}
+	if line, col := lex.LineText(14); line != `Süß = "ä" für äx` || col != 15 {
+		t.Errorf("LineText(14) = %q, %d", line, col)
+	}
+	if got := lex.Context(14, 2); got != "r äx" {
+		t.Errorf("Context(14, 2) = %q, want \"r äx\"", got)
+	}
+	m := NewLineMap(src)
+	m.Encoding = Latin1
+	if line, col := m.LineCol(14); line != 1 || col != 15 {
+		t.Errorf("LineCol(14) = %d, %d, want 1, 15", line, col)
+	}
+
+	// ByteValues gives the input bytes.
+	opts.ByteValues = true
+	if tok := NewLexerWithOptions(src, opts).NextToken(); string(tok.Bytes) != "S\xfc\xdf" {
+		t.Errorf("got Bytes %q, want %q", tok.Bytes, "S\xfc\xdf")
+	}
+
+	// As UTF-8, the same input is invalid.
+	if toks := NewLexer(src).Tokens(); toks[1].Msg != "invalid UTF-8" {
+		t.Errorf("got %v, want an invalid UTF-8 error", toks)
+	}
+}
+
+func TestNul(t *testing.T) {
+	src := []byte("ab\x00cd \x00 \"x\x00y\" // z\x00z\n\x00")
+	tests := []struct {
+		nul  NulHandling
+		want []string
+	}{
+		{NulError, []string{"IDENTIFIER ab", "ERROR "}},
+		{NulWhitespace, []string{"IDENTIFIER ab", "IDENTIFIER cd", "QUOTE \"x\x00y\"", "COMMENT // z\x00z", "EOF "}},
+		{NulToken, []string{"IDENTIFIER ab", "NUL \x00", "IDENTIFIER cd", "NUL \x00", "QUOTE \"x\x00y\"", "COMMENT // z\x00z", "NUL \x00", "EOF "}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, tok := range NewLexerWithOptions(src, Options{Nul: tt.nul}).Tokens() {
+			got = append(got, tok.Name.String()+" "+tok.Val)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Nul %d: got %q, want %q", tt.nul, got, tt.want)
+		}
+	}
+
+	// A NUL at the end isn't mistaken for EOF, and EOF comes after it.
+	toks := NewLexerWithOptions([]byte("a\x00"), Options{Nul: NulToken}).Tokens()
+	if len(toks) != 3 || toks[1].Name != NUL || toks[2].Name != EOF || toks[2].Pos.Offset != 2 {
+		t.Errorf("got %v, want a, NUL and EOF at 2", toks)
+	}
+}
+
+func TestReadGroup(t *testing.T) {
+	vals := func(toks []Token) string {
+		var vals []string
+		for _, tok := range toks {
+			vals = append(vals, tok.Val)
+		}
+		return strings.Join(vals, " ")
+	}
+
+	lex := NewLexer([]byte("(a, (b, [c]), d) e"))
+	toks, err := lex.ReadGroup(L_PAREN, R_PAREN)
+	if err != nil || vals(toks) != "a , ( b , [ c ] ) , d" {
+		t.Errorf("ReadGroup = %q, %v", vals(toks), err)
+	}
+	if tok := lex.NextToken(); tok.Val != "e" {
+		t.Errorf("after the group got %v, want e", tok)
+	}
+
+	lex = NewLexer([]byte("[]"))
+	if toks, err := lex.ReadGroup(L_BRACKET, R_BRACKET); err != nil || len(toks) != 0 {
+		t.Errorf("empty group: ReadGroup = %v, %v", toks, err)
+	}
+
+	lex = NewLexer([]byte("|a|"))
+	if toks, err := lex.ReadGroup(PIPE, PIPE); err != nil || vals(toks) != "a" {
+		t.Errorf("same delimiters: ReadGroup = %q, %v", vals(toks), err)
+	}
+
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"x (", "1:1: expected L_PAREN, found IDENTIFIER"},
+		{"(a (b)", "1:1: unclosed ("},
+		{"(a ^)", "1:4: unexpected character '^'"},
+	}
+	for _, tt := range tests {
+		lex := NewLexer([]byte(tt.src))
+		if _, err := lex.ReadGroup(L_PAREN, R_PAREN); err == nil || err.Error() != tt.err {
+			t.Errorf("%q: got error %v, want %q", tt.src, err, tt.err)
+		}
+	}
+	lex = NewLexer([]byte("x"))
+	lex.ReadGroup(L_PAREN, R_PAREN)
+	if tok := lex.NextToken(); tok.Val != "x" {
+		t.Errorf("after a failed ReadGroup got %v, want x", tok)
+	}
+}
+
+func TestLastToken(t *testing.T) {
+	lex := NewLexer([]byte("a = /* c */ 1 +\nb"))
+	if tok := lex.LastToken(); tok.Name != NONE {
+		t.Errorf("LastToken() = %v at the start, want NONE", tok)
+	}
+	for _, want := range []string{"a", "=", "=", "1", "+", "b", ""} {
+		tok := lex.NextToken()
+		if last := lex.LastToken(); last.Val != want {
+			t.Errorf("after %v: LastToken() = %v, want %q", tok, last, want)
+		}
+	}
+	if tok := lex.LastToken(); tok.Name != EOF {
+		t.Errorf("LastToken() = %v at the end, want EOF", tok)
+	}
+
+	// Skipped tokens don't count.
+	lex = NewLexerWithOptions([]byte("a;\nb ;"), Options{SignificantNewlines: true, Skip: map[TokenName]bool{SEMI: true, NEWLINE: true}})
+	for _, want := range []string{"a", "b", ""} {
+		tok := lex.NextToken()
+		if last := lex.LastToken(); !last.Equal(tok) || last.Val != want {
+			t.Errorf("after %v: LastToken() = %v, want %q", tok, last, want)
+		}
+	}
+
+	// Peeking scans ahead.
+	lex = NewLexer([]byte("x y"))
+	lex.NextToken()
+	lex.PeekToken()
+	if tok := lex.LastToken(); tok.Val != "y" {
+		t.Errorf("LastToken() = %v after PeekToken, want y", tok)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	src := syntheticInput(10000)
+	lex := NewLexer(src)
+	if consumed, total := lex.Progress(); consumed != 0 || total != len(src) {
+		t.Fatalf("Progress() = %d, %d at the start, want 0, %d", consumed, total, len(src))
+	}
+	last := 0
+	for tok := lex.NextToken(); tok.Name != EOF; tok = lex.NextToken() {
+		consumed, total := lex.Progress()
+		if consumed < last || consumed > total || total != len(src) {
+			t.Fatalf("after %v: Progress() = %d, %d, previously %d", tok, consumed, total, last)
+		}
+		last = consumed
+	}
+	if consumed, total := lex.Progress(); consumed != len(src) || total != len(src) {
+		t.Errorf("Progress() = %d, %d at EOF, want %d, %d", consumed, total, len(src), len(src))
+	}
+
+	lex = NewLexerReader(bytes.NewReader(src))
+	for lex.NextToken().Name != EOF {
+	}
+	if consumed, total := lex.Progress(); consumed != len(src) || total != -1 {
+		t.Errorf("reader Progress() = %d, %d at EOF, want %d, -1", consumed, total, len(src))
+	}
+}
+
+func TestLexCollect(t *testing.T) {
+	src := "a ^b c\n'' d \"x\\qy\" e\n\"f"
+	toks, errs := LexCollect([]byte(src))
+	var vals []string
+	for _, tok := range toks {
+		vals = append(vals, tok.Val)
+	}
+	if want := []string{"a", "c", "d", "e", ""}; !reflect.DeepEqual(vals, want) {
+		t.Errorf("got tokens %q, want %q", vals, want)
+	}
+	var msgs []string
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	want := []string{
+		"1:3: unexpected character '^'",
+		"2:1: empty character literal",
+		"2:8: invalid escape sequence",
+		"3:1: unterminated string literal",
+	}
+	if !reflect.DeepEqual(msgs, want) {
+		t.Errorf("got errors %q, want %q", msgs, want)
+	}
+}
+
+func TestErrorList(t *testing.T) {
+	var errs ErrorList
+	if s := errs.Error(); s != "no errors" {
+		t.Errorf("empty Error() = %q", s)
+	}
+	if err := errs.Err(); err != nil {
+		t.Errorf("empty Err() = %v, want nil", err)
+	}
+
+	errs = ErrorList{
+		{Position{10, 2, 3}, "b"},
+		{Position{0, 1, 1}, "a"},
+		{Position{10, 2, 3}, "c"},
+		{Position{4, 1, 5}, "d"},
+	}
+	if s := errs[:1].Error(); s != "2:3: b" {
+		t.Errorf("Error() = %q, want %q", s, "2:3: b")
+	}
+	if s := errs.Err().Error(); s != "2:3: b (and 3 more errors)" {
+		t.Errorf("Error() = %q, want %q", s, "2:3: b (and 3 more errors)")
+	}
+	errs.Sort()
+	var msgs []string
+	for _, err := range errs {
+		msgs = append(msgs, err.Msg)
+	}
+	if want := []string{"a", "d", "b", "c"}; !reflect.DeepEqual(msgs, want) {
+		t.Errorf("sorted %q, want %q", msgs, want)
+	}
+}
+
+func TestLineCommentPrefixes(t *testing.T) {
+	src := []byte("a # b\nc ; d\ne % f\ng // h\ni -- j /* k */")
+	tests := []struct {
+		prefixes []string
+		want     []string
+	}{
+		{nil, []string{"a", "#", "b", "c", ";", "d", "e", "%", "f", "g", "// h", "i", "-", "-", "j", "/* k */", ""}},
+		{[]string{"#"}, []string{"a", "# b", "c", ";", "d", "e", "%", "f", "g", "/", "/", "h", "i", "-", "-", "j", "/* k */", ""}},
+		{[]string{";"}, []string{"a", "#", "b", "c", "; d", "e", "%", "f", "g", "/", "/", "h", "i", "-", "-", "j", "/* k */", ""}},
+		{[]string{"%", "//"}, []string{"a", "#", "b", "c", ";", "d", "e", "% f", "g", "// h", "i", "-", "-", "j", "/* k */", ""}},
+		{[]string{"--"}, []string{"a", "#", "b", "c", ";", "d", "e", "%", "f", "g", "/", "/", "h", "i", "-- j /* k */", ""}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, tok := range NewLexerWithOptions(src, Options{LineCommentPrefixes: tt.prefixes}).Tokens() {
+			got = append(got, tok.Val)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.prefixes, got, tt.want)
+		}
+	}
+
+	toks := NewLexerWithOptions(src, Options{LineCommentPrefixes: []string{"#"}, SkipComments: true}).Tokens()
+	if toks[1].Val != "c" {
+		t.Errorf("with SkipComments, got %v after a, want c", toks[1])
+	}
+}
+
+func TestStats(t *testing.T) {
+	lex := NewLexerWithOptions([]byte("def x : Foo<1, 2> { // c\n  let y = 0x3; }"), Options{CollectStats: true, Keywords: TableGenKeywords})
+	lex.PeekToken()
+	lex.Unread(lex.NextToken())
+	for lex.NextToken().Name != EOF {
+	}
+	want := map[TokenName]int{
+		DEF: 1, LET: 1, IDENTIFIER: 3, NUMBER: 3, COMMENT: 1,
+		COLON: 1, L_ANG: 1, COMMA: 1, R_ANG: 1, L_BRACE: 1, EQUALS: 1, SEMI: 1, R_BRACE: 1, EOF: 1,
+	}
+	if got := lex.Stats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Stats() = %v, want %v", got, want)
+	}
+
+	lex = NewLexer([]byte("a b"))
+	lex.Tokens()
+	if stats := lex.Stats(); stats != nil {
+		t.Errorf("Stats() without CollectStats = %v, want nil", stats)
+	}
+}
+
+func TestIdentPredicates(t *testing.T) {
+	cssCont := func(r rune) bool { return isIdentCont(r) || r == '-' }
+	cssStart := func(r rune) bool { return isIdentStart(r) || r == '@' || r == '-' }
+	tests := []struct {
+		opts Options
+		src  string
+		want []string
+	}{
+		{Options{}, "foo-bar x", []string{"foo", "-", "bar", "x", ""}},
+		{Options{IsIdentCont: cssCont}, "foo-bar -x", []string{"foo-bar", "-", "x", ""}},
+		{Options{IsIdentStart: cssStart, IsIdentCont: cssCont}, "foo-bar @media -webkit-x", []string{"foo-bar", "@media", "-webkit-x", ""}},
+		{Options{IsIdentStart: func(r rune) bool { return r == '#' || isIdentStart(r) }}, "#a1 b", []string{"#a1", "b", ""}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, tok := range NewLexerWithOptions([]byte(tt.src), tt.opts).Tokens() {
+			got = append(got, tok.Val)
+			if tok.Val == tt.want[0] && tok.Name != IDENTIFIER {
+				t.Errorf("%q: %v isn't an IDENTIFIER", tt.src, tok)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestSignedNumbers(t *testing.T) {
+	tests := []struct {
+		opts Options
+		src  string
+		want []string
+	}{
+		{Options{}, "[-3, +4]", []string{"[", "-", "3", ",", "+", "4", "]", ""}},
+		{Options{SignedNumbers: true}, "[-3, +4, -.5e1, - 1, -0x1F]", []string{"[", "-3", ",", "+4", ",", "-.5e1", ",", "-", "1", ",", "-0x1F", "]", ""}},
+		{Options{SignedNumbers: true}, "-1 a -2 (-3) x = /* c */ -4", []string{"-1", "a", "-", "2", "(", "-3", ")", "x", "=", "/* c */", "-4", ""}},
+		{Options{SignedNumbers: true, SignedNumberAfter: []TokenName{IDENTIFIER}}, "a -2, -3", []string{"a", "-2", ",", "-", "3", ""}},
+		{Options{SignedNumbers: true, SignificantNewlines: true}, "a\n-1", []string{"a", "\n", "-1", ""}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, tok := range NewLexerWithOptions([]byte(tt.src), tt.opts).Tokens() {
+			got = append(got, tok.Val)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.src, got, tt.want)
+		}
+	}
+
+	toks := NewLexerWithOptions([]byte("-0x1F +7 -2.5 -0b11"), Options{SignedNumbers: true, SignedNumberAfter: []TokenName{NUMBER}}).Tokens()
+	for i, want := range []float64{-31, 7, -2.5, -3} {
+		if f, err := toks[i].Float(); err != nil || f != want {
+			t.Errorf("%v.Float() = %g, %v, want %g", toks[i], f, err, want)
+		}
+	}
+	if n, err := toks[0].Int(); err != nil || n != -31 {
+		t.Errorf("%v.Int() = %d, %v, want -31", toks[0], n, err)
+	}
+}
+
+func TestAutoSemi(t *testing.T) {
+	names := func(tokens []Token) []TokenName {
+		var names []TokenName
+		for _, tok := range tokens {
+			names = append(names, tok.Name)
+		}
+		return names
+	}
+	tests := []struct {
+		opts Options
+		src  string
+		want []TokenName
+	}{
+		{Options{}, "x\n", []TokenName{IDENTIFIER, EOF}},
+		{Options{AutoSemi: true}, "x\n", []TokenName{IDENTIFIER, SEMI, EOF}},
+		{Options{AutoSemi: true}, "x +\ny", []TokenName{IDENTIFIER, PLUS, IDENTIFIER, SEMI, EOF}},
+		{Options{AutoSemi: true}, "f(a,\n b)  \r\n\n[1]", []TokenName{IDENTIFIER, L_PAREN, IDENTIFIER, COMMA, IDENTIFIER, R_PAREN, SEMI, L_BRACKET, NUMBER, R_BRACKET, SEMI, EOF}},
+		{Options{AutoSemi: true}, "s = \"a\"; // c\n", []TokenName{IDENTIFIER, EQUALS, QUOTE, SEMI, COMMENT, EOF}},
+		{Options{AutoSemi: true}, "x // c\n{}", []TokenName{IDENTIFIER, COMMENT, SEMI, L_BRACE, R_BRACE, SEMI, EOF}},
+		{Options{AutoSemi: true, SignificantNewlines: true}, "x\n+", []TokenName{IDENTIFIER, SEMI, NEWLINE, PLUS, EOF}},
+		{Options{AutoSemi: true, EmitWhitespace: true}, "x \n", []TokenName{IDENTIFIER, WHITESPACE, SEMI, WHITESPACE, EOF}},
+		{Options{AutoSemi: true, AutoSemiAfter: []TokenName{PLUS}}, "x +\ny", []TokenName{IDENTIFIER, PLUS, SEMI, IDENTIFIER, EOF}},
+	}
+	for _, tt := range tests {
+		if got := names(NewLexerWithOptions([]byte(tt.src), tt.opts).Tokens()); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.src, got, tt.want)
+		}
+	}
+
+	// The SEMI is positioned at the line break and has no value.
+	toks := NewLexerWithOptions([]byte("ab  \ncd"), Options{AutoSemi: true}).Tokens()
+	want := []Token{
+		{Name: IDENTIFIER, Val: "ab", Pos: Position{0, 1, 1}},
+		{Name: SEMI, Pos: Position{4, 1, 5}},
+		{Name: IDENTIFIER, Val: "cd", Pos: Position{5, 2, 1}},
+		{Name: SEMI, Pos: Position{7, 2, 3}},
+		{Name: EOF, Pos: Position{7, 2, 3}},
+	}
+	if !TokensEqual(toks, want) {
+		t.Errorf("got %v, want %v", toks, want)
+	}
+}
+
+func TestUnitSuffixes(t *testing.T) {
+	src := []byte("100ms 3.3V 5 1.5GHz 2e3Hz 10µs 0x1F x")
+	type nu struct{ val, unit string }
+	tests := []struct {
+		opts Options
+		want []nu
+	}{
+		{Options{}, []nu{{"100", ""}, {"ms", ""}, {"3.3", ""}, {"V", ""}, {"5", ""}, {"1.5", ""}, {"GHz", ""},
+			{"2e3", ""}, {"Hz", ""}, {"10", ""}, {"µs", ""}, {"0x1F", ""}, {"x", ""}, {"", ""}}},
+		{Options{UnitSuffixes: true}, []nu{{"100", "ms"}, {"3.3", "V"}, {"5", ""}, {"1.5", "GHz"},
+			{"2e3", "Hz"}, {"10", "µs"}, {"0x1F", ""}, {"x", ""}, {"", ""}}},
+	}
+	for _, tt := range tests {
+		var got []nu
+		for _, tok := range NewLexerWithOptions(src, tt.opts).Tokens() {
+			got = append(got, nu{tok.Val, tok.Unit})
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%+v: got %q, want %q", tt.opts, got, tt.want)
+		}
+	}
+
+	toks := NewLexerWithOptions([]byte("3.3V x"), Options{UnitSuffixes: true}).Tokens()
+	if f, err := toks[0].Float(); err != nil || f != 3.3 || toks[1].Pos.Offset != 5 {
+		t.Errorf("got %v (%g, %v) followed by %v", toks[0], f, err, toks[1])
+	}
+
+	// Reading more input while scanning the unit mustn't lose its start.
+	opts := Options{UnitSuffixes: true}
+	want := NewLexerWithOptions(src, opts).Tokens()
+	if got := NewLexerReaderWithOptions(iotest.OneByteReader(bytes.NewReader(src)), opts).Tokens(); !reflect.DeepEqual(got, want) {
+		t.Errorf("reader got %v, want %v", got, want)
+	}
+}
+
+func TestRepeatedEOF(t *testing.T) {
+	tests := []struct {
+		src  string
+		opts Options
+	}{
+		{"", Options{}},
+		{"a é", Options{}},
+		{"x\n  y\n    é", Options{Indentation: true, SignificantNewlines: true}},
+		{"a /* é", Options{}},
+		{"\"é", Options{}},
+	}
+	for _, tt := range tests {
+		for _, lex := range []*Lexer{NewLexerWithOptions([]byte(tt.src), tt.opts), NewLexerReaderWithOptions(strings.NewReader(tt.src), tt.opts)} {
+			tok := lex.NextToken()
+			for i := 0; tok.Name != EOF; i++ {
+				if i > 20 {
+					t.Fatalf("%q: no EOF", tt.src)
+				}
+				tok = lex.NextToken()
+			}
+			eof := tok
+			if eof.Pos.Offset != len(tt.src) {
+				t.Errorf("%q: EOF at offset %d, want %d", tt.src, eof.Pos.Offset, len(tt.src))
+			}
+			for i := 0; i < 5; i++ {
+				if tok := lex.NextToken(); !reflect.DeepEqual(tok, eof) {
+					t.Errorf("%q: call %d after EOF returned %v at %s, want %v at %s", tt.src, i+1, tok, tok.Pos, eof, eof.Pos)
+				}
+			}
+		}
+	}
+}
+
+func TestBOMAndShebang(t *testing.T) {
+	const bom = "\uFEFF"
+	tests := []struct {
+		src  string
+		opts Options
+		want []Token
+	}{
+		{bom + "a b", Options{}, []Token{
+			{Name: IDENTIFIER, Val: "a", Pos: Position{3, 1, 1}},
+			{Name: IDENTIFIER, Val: "b", Pos: Position{5, 1, 3}},
+			{Name: EOF, Pos: Position{6, 1, 4}},
+		}},
+		{bom, Options{}, []Token{{Name: EOF, Pos: Position{3, 1, 1}}}},
+		{"#!/usr/bin/env tblgen\nx", Options{SkipShebang: true}, []Token{
+			{Name: IDENTIFIER, Val: "x", Pos: Position{22, 2, 1}},
+			{Name: EOF, Pos: Position{23, 2, 2}},
+		}},
+		{bom + "#! x\n#!", Options{SkipShebang: true}, []Token{
+			{Name: POUND, Val: "#", Pos: Position{8, 2, 1}},
+			{Name: EXCLAMATION, Val: "!", Pos: Position{9, 2, 2}},
+			{Name: EOF, Pos: Position{10, 2, 3}},
+		}},
+		{"#!x", Options{}, []Token{
+			{Name: POUND, Val: "#", Pos: Position{0, 1, 1}},
+			{Name: EXCLAMATION, Val: "!", Pos: Position{1, 1, 2}},
+			{Name: IDENTIFIER, Val: "x", Pos: Position{2, 1, 3}},
+			{Name: EOF, Pos: Position{3, 1, 4}},
+		}},
+		{"a" + bom, Options{}, []Token{
+			{Name: IDENTIFIER, Val: "a", Pos: Position{0, 1, 1}},
+			{Name: ERROR, Pos: Position{1, 1, 2}, Msg: "unexpected character '\\ufeff'"},
+		}},
+	}
+	for _, tt := range tests {
+		if got := NewLexerWithOptions([]byte(tt.src), tt.opts).Tokens(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestDottedIdentifiers(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{"a.b.c", []string{"a.b.c", ""}},
+		{"a. b", []string{"a", ".", "b", ""}},
+		{"a.", []string{"a", ".", ""}},
+		{"a..b", []string{"a", "..", "b", ""}},
+		{"a.1", []string{"a", ".1", ""}},
+		{"ns.été.x(y.z)", []string{"ns.été.x", "(", "y.z", ")", ""}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, tok := range NewLexerWithOptions([]byte(tt.src), Options{DottedIdentifiers: true}).Tokens() {
+			got = append(got, tok.Val)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.src, got, tt.want)
+		}
+	}
+	if toks := Lex([]byte("a.b")); len(toks) != 4 || toks[1].Name != PERIOD {
+		t.Errorf("without DottedIdentifiers, got %v", toks)
+	}
+}
+
+func TestCheckBalanced(t *testing.T) {
+	tests := []struct {
+		src       string
+		err, angs string
+	}{
+		{"f(a[1], {b}) x", "", ""},
+		{"class A<list<int> x> { let y = [(1)]; }", "", ""},
+		{"(a", "1:1: unclosed (", "1:1: unclosed ("},
+		{"a)", "1:2: unmatched )", "1:2: unmatched )"},
+		{"{ [ ( ] ) }", "1:7: ] doesn't match ( at 1:5", "1:7: ] doesn't match ( at 1:5"},
+		{"(\n  [}\n)", "2:4: } doesn't match [ at 2:3", "2:4: } doesn't match [ at 2:3"},
+		{"a < b", "", "1:3: unclosed <"},
+		{"(a>)", "", "1:3: > doesn't match ( at 1:1"},
+	}
+	for _, tt := range tests {
+		toks := Lex([]byte(tt.src))
+		for _, c := range []struct {
+			check func([]Token) error
+			want  string
+		}{{CheckBalanced, tt.err}, {CheckBalancedAngles, tt.angs}} {
+			err := c.check(toks)
+			if got := fmt.Sprint(err); err == nil && c.want != "" || err != nil && got != c.want {
+				t.Errorf("%q: got error %v, want %q", tt.src, err, c.want)
+			}
+		}
+	}
+}
+
+func TestRuneOffsets(t *testing.T) {
+	src := "\uFEFF\"本ä\" x\n日 /* é\n */ ö ^"
+	want := []struct{ bytes, runes int }{{3, 1}, {11, 6}, {13, 8}, {17, 10}, {27, 19}, {30, 21}}
+	lex := NewLexerWithOptions([]byte(src), Options{TrackRuneOffsets: true})
+	for i, w := range want {
+		tok := lex.NextToken()
+		if tok.Pos.Offset != w.bytes || tok.RuneOffset != w.runes {
+			t.Errorf("token %d %v: offsets %d bytes, %d runes; want %d, %d", i, tok, tok.Pos.Offset, tok.RuneOffset, w.bytes, w.runes)
+		}
+		if n := utf8.RuneCountInString(src[:tok.Pos.Offset]); n != tok.RuneOffset {
+			t.Errorf("token %d %v: rune offset %d, but %d runes precede it", i, tok, tok.RuneOffset, n)
+		}
+	}
+	if tok := Lex([]byte(src))[1]; tok.RuneOffset != 0 {
+		t.Errorf("RuneOffset = %d without TrackRuneOffsets", tok.RuneOffset)
+	}
+}
+
+func TestStream(t *testing.T) {
+	var toks []Token
+	for tok := range NewLexer([]byte("a + 1")).Stream(context.Background()) {
+		toks = append(toks, tok)
+	}
+	if len(toks) != 4 || toks[3].Name != EOF {
+		t.Errorf("got %v, want a, +, 1, EOF", toks)
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	src := bytes.Repeat([]byte("foo bar "), 100000)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := NewLexer(src).Stream(ctx)
+
+	for i := 0; i < 3; i++ {
+		<-ch
+	}
+	cancel()
+
+	// The goroutine must notice the cancellation and close the channel long
+	// before it gets to the end of the input.
+	timeout := time.After(5 * time.Second)
+	n := 0
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				if n > 1 {
+					t.Errorf("received %d tokens after cancel", n)
+				}
+				return
+			}
+			n++
+		case <-timeout:
+			t.Fatal("stream not closed after cancel")
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	lex := NewLexerWithOptions([]byte("a /* c */ \"unterminated"), Options{SkipComments: true})
+	lex.PeekToken()
+	lex.Unread(lex.NextToken())
+	lex.Tokens()
+
+	lex.Reset([]byte("x\n/* c */ y"))
+	toks := lex.Tokens()
+	if len(toks) != 3 || toks[0].Val != "x" || toks[1].Val != "y" || toks[1].Pos.Line != 2 || toks[2].Name != EOF {
+		t.Errorf("after Reset got %v, want x, y on line 2, EOF", toks)
+	}
+}
+
+var snippet = []byte("def foo : bar<1, 2>;")
+
+func BenchmarkNewLexer(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		lex := NewLexer(snippet)
+		for lex.NextToken().Name != EOF {
+		}
+	}
+}
+
+func BenchmarkReset(b *testing.B) {
+	b.ReportAllocs()
+	lex := NewLexer(nil)
+	for i := 0; i < b.N; i++ {
+		lex.Reset(snippet)
+		for lex.NextToken().Name != EOF {
+		}
+	}
+}
+
+func TestUnicodeIdentifiers(t *testing.T) {
+	var tests = []struct {
+		input string
+		vals  []string
+	}{
+		{"café", []string{"café"}},
+		{"cafe\u0301 x", []string{"cafe\u0301", "x"}},
+		{"π", []string{"π"}},
+		{"café x", []string{"café", "x"}},
+		{"本ä", []string{"本ä"}},
+		{"変数1 _x", []string{"変数1", "_x"}},
+		{"x١٢", []string{"x١٢"}},
+		{"a+ü", []string{"a", "+", "ü"}},
+	}
+
+	for _, tt := range tests {
+		toks := testParse([]byte(tt.input))
+		if len(toks) != len(tt.vals)+1 {
+			t.Errorf("%q: expected %d tokens, got %v", tt.input, len(tt.vals)+1, toks)
+			continue
+		}
+		for i, val := range tt.vals {
+			if toks[i].Val != val || toks[i].Name == ERROR {
+				t.Errorf("%q: token %d: got %v, want '%s'", tt.input, i, toks[i], val)
+			}
+		}
+	}
+
+	// A combining mark can't begin an identifier.
+	if tok := NewLexer([]byte("\u0301a")).NextToken(); tok.Name != ERROR {
+		t.Errorf("got %v, want ERROR", tok)
+	}
+}
+
+func TestDigitSeparators(t *testing.T) {
+	var tests = []struct {
+		input string
+		vals  []string
+		clean string
+	}{
+		{"1_000_000", []string{"1_000_000"}, "1000000"},
+		{"0xFF_FF", []string{"0xFF_FF"}, "0xFFFF"},
+		{"0b1_0", []string{"0b1_0"}, "0b10"},
+		{"1_0.2_5e1", []string{"1_0.2_5e1"}, "10.25e1"},
+		{"1e1_0", []string{"1e1", "_0"}, "1e1"},
+		{"0_7", []string{"0_7"}, "07"},
+		{"1_", []string{"1", "_"}, "1"},
+		{"1__2", []string{"1", "__2"}, "1"},
+		{"0x_1", []string{"0", "x_1"}, "0"},
+		{"_1", []string{"_1"}, "1"},
+	}
+
+	for _, tt := range tests {
+		toks := testParse([]byte(tt.input))
+		if len(toks) != len(tt.vals)+1 {
+			t.Errorf("%q: expected %d tokens, got %v", tt.input, len(tt.vals)+1, toks)
+			continue
+		}
+		for i, val := range tt.vals {
+			if toks[i].Val != val {
+				t.Errorf("%q: token %d: got %v, want '%s'", tt.input, i, toks[i], val)
+			}
+		}
+		if clean := toks[0].CleanNumber(); clean != tt.clean {
+			t.Errorf("%q: CleanNumber() = %q, want %q", tt.input, clean, tt.clean)
+		}
+	}
+
+	if n, err := Lex([]byte("0xFF_FF"))[0].Int(); err != nil || n != 0xFFFF {
+		t.Errorf("Int() = %d, %v; want %d", n, err, 0xFFFF)
+	}
+
+	// Int and Float reject misplaced separators in a hand-built token.
+	for _, val := range []string{"1__0", "1_", "0x_1", "0b1_", "1._5", "1e5_0"} {
+		tok := Token{Name: NUMBER, Val: val}
+		if _, err := tok.Int(); !errors.Is(err, strconv.ErrSyntax) {
+			t.Errorf("%q: Int() error = %v, want a syntax error", val, err)
+		}
+		if _, err := tok.Float(); !errors.Is(err, strconv.ErrSyntax) {
+			t.Errorf("%q: Float() error = %v, want a syntax error", val, err)
+		}
+	}
+}
+
+func TestTypedAccessors(t *testing.T) {
+	toks := Lex([]byte(`1_000 0x1F 2.5e-1 "a\tb" foo`))
+
+	if n, err := toks[0].Int(); err != nil || n != 1000 {
+		t.Errorf("Int() = %d, %v; want 1000", n, err)
+	}
+	if f, err := toks[1].Float(); err != nil || f != 31 {
+		t.Errorf("Float() = %g, %v; want 31", f, err)
+	}
+	if f, err := toks[2].Float(); err != nil || f != 0.25 {
+		t.Errorf("Float() = %g, %v; want 0.25", f, err)
+	}
+	if _, err := toks[2].Int(); err == nil {
+		t.Errorf("Int() of %v succeeded", toks[2])
+	}
+	if s := toks[3].Text(); s != "a\tb" {
+		t.Errorf("Text() = %q, want %q", s, "a\tb")
+	}
+	if s := toks[4].Text(); s != "foo" {
+		t.Errorf("Text() = %q, want %q", s, "foo")
+	}
+	if _, err := toks[4].Int(); err == nil || err.Error() != "cannot convert IDENTIFIER token to a number" {
+		t.Errorf("Int() of %v: got error %v", toks[4], err)
+	}
+	if _, err := toks[3].Float(); err == nil {
+		t.Errorf("Float() of %v succeeded", toks[3])
+	}
+}
+
+func TestNoneSentinel(t *testing.T) {
+	if NONE != 0 || ERROR == NONE {
+		t.Errorf("NONE = %d, ERROR = %d; want NONE to be the zero value", NONE, ERROR)
+	}
+	if opTable['a'] != NONE || opTable[0] != NONE {
+		t.Errorf("unmapped opTable entries are %v, %v; want NONE", opTable['a'], opTable[0])
+	}
+	var tok Token
+	if tok.Name != NONE {
+		t.Errorf("zero Token has name %v, want NONE", tok.Name)
+	}
+}
+
+func TestTokenNameString(t *testing.T) {
+	if s := fmt.Sprint(L_PAREN); s != "L_PAREN" {
+		t.Errorf("got %q, want %q", s, "L_PAREN")
+	}
+	if s := fmt.Sprintf("%v", TokenName(1000)); s != "TokenName(1000)" {
+		t.Errorf("got %q, want %q", s, "TokenName(1000)")
+	}
+	if s := TokenName(-1).String(); s != "TokenName(-1)" {
+		t.Errorf("got %q, want %q", s, "TokenName(-1)")
+	}
+	for n := range tokenNames {
+		if TokenName(n).String() != tokenNames[n] {
+			t.Errorf("TokenName(%d).String() = %q, want %q", n, TokenName(n), tokenNames[n])
+		}
+	}
+}
+
+func TestTokenCategory(t *testing.T) {
+	want := map[TokenCategory][]TokenName{
+		CatNone:       {NONE},
+		CatIdentifier: {IDENTIFIER},
+		CatKeyword:    {CLASS, DEF, DEFM, FIELD, FOREACH, IN, INCLUDE, LET, MULTICLASS},
+		CatNumber:     {NUMBER},
+		CatString:     {QUOTE, RAW_QUOTE, BLOCK_QUOTE, CHAR},
+		CatOperator: {
+			PLUS, MINUS, MULTIPLY, DIVIDE, PERIOD, BACKSLASH, COLON, PERCENT,
+			PIPE, EXCLAMATION, QUESTION, POUND, AMPERSAND, SEMI, COMMA,
+			L_PAREN, R_PAREN, L_ANG, R_ANG, L_BRACE, R_BRACE, L_BRACKET,
+			R_BRACKET, EQUALS, AT, TILDE, EQ_EQ, NOT_EQ, LE, GE, AND_AND, OR_OR, COLON_EQ,
+			ARROW, PLUS_EQ, MINUS_EQ, COLON_COLON, RANGE, SHL, SHR,
+		},
+		CatComment:    {COMMENT},
+		CatWhitespace: {NEWLINE, INDENT, DEDENT, WHITESPACE, NUL},
+		CatError:      {ERROR},
+		CatEOF:        {EOF},
+	}
+	seen := make(map[TokenName]bool)
+	for cat, names := range want {
+		for _, n := range names {
+			seen[n] = true
+			if got := n.Category(); got != cat {
+				t.Errorf("%v.Category() = %v, want %v", n, got, cat)
+			}
+		}
+	}
+	// Every token name needs a category, so new names must be added above.
+	for n := range tokenNames {
+		if !seen[TokenName(n)] {
+			t.Errorf("%v missing from test table", TokenName(n))
+		}
+	}
+	if got := TokenName(1000).Category(); got != CatNone {
+		t.Errorf("TokenName(1000).Category() = %v, want CatNone", got)
+	}
+	if s := TokenCategory(-1).String(); s != "TokenCategory(-1)" {
+		t.Errorf("got %q, want %q", s, "TokenCategory(-1)")
+	}
+}
+
+func FuzzNextToken(f *testing.F) {
+	for _, seed := range []string{
+		"def foo : bar<1, 2>; // comment",
+		`"本ä" "a\"b" "unterminated`,
+		"/* block */ /* open",
+		"0x1F 0b1010 1_000 3.14e-5 1e+ ..",
+		"\\",
+		"\xff\xfe\x80",
+		"a\r\nb\rc\n",
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		lex := NewLexer(buf)
+		pos := 0
+		// Every token but the last consumes at least one byte, so this bounds
+		// the loop.
+		for i := 0; i <= len(buf); i++ {
+			tok := lex.NextToken()
+			if tok.Pos.Offset < pos || tok.Pos.Offset > len(buf) {
+				t.Fatalf("token %v out of order after position %d", tok, pos)
+			}
+			if tok.Name != ERROR && string(buf[tok.Pos.Offset:tok.Pos.Offset+len(tok.Val)]) != tok.Val {
+				t.Fatalf("token %v doesn't match the input", tok)
+			}
+			pos = tok.Pos.Offset
+			if tok.Name == EOF || tok.Name == ERROR {
+				return
+			}
+		}
+		t.Fatalf("no EOF after %d tokens", len(buf)+1)
+	})
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	toks := testParse([]byte{0xFF, 0xFE})
+	if len(toks) != 3 {
+		t.Fatalf("got %v, want two ERRORs followed by EOF", toks)
+	}
+	for i, pos := range []int{0, 1} {
+		if toks[i].Name != ERROR || toks[i].Pos.Offset != pos || toks[i].Msg != "invalid UTF-8" {
+			t.Errorf("token %d: got %v (%q), want invalid UTF-8 ERROR at %d", i, toks[i], toks[i].Msg, pos)
+		}
+	}
+	if toks[2].Name != EOF || toks[2].Pos.Offset != 2 {
+		t.Errorf("got %v, want EOF at 2", toks[2])
+	}
+
+	// An invalid byte ends an identifier, and lexing resumes after it.
+	toks = testParse([]byte("ab\xffcd"))
+	if len(toks) != 4 || toks[0].Val != "ab" || toks[1].Name != ERROR || toks[1].Pos.Col != 3 || toks[2].Val != "cd" || toks[2].Pos.Col != 4 {
+		t.Errorf("got %v, want ab, ERROR, cd", toks)
+	}
+
+	// A properly encoded U+FFFD is not an encoding error.
+	if tok := NewLexer([]byte("\uFFFD")).NextToken(); tok.Msg == "invalid UTF-8" {
+		t.Errorf("got %v (%q) for U+FFFD", tok, tok.Msg)
+	}
+}
+
+func TestOperatorDispatch(t *testing.T) {
+	// Every entry in opTable lexes as its operator on its own.
+	for r, name := range opTable {
+		if name == NONE {
+			continue
+		}
+		toks := testParse([]byte{byte(r)})
+		if len(toks) != 2 || toks[0].Name != name || toks[0].Val != string(rune(r)) {
+			t.Errorf("%q: got %v, want %s", rune(r), toks, name)
+		}
+	}
+
+	// Unmapped runes below len(opTable) fall through to the other scanners.
+	var tests = []struct {
+		input string
+		name  TokenName
+	}{
+		{"a", IDENTIFIER},
+		{"_", IDENTIFIER},
+		{"7", NUMBER},
+		{`""`, QUOTE},
+		{"^", ERROR},
+		{"`", ERROR},
+	}
+	for _, tt := range tests {
+		if tok := NewLexer([]byte(tt.input)).NextToken(); tok.Name != tt.name {
+			t.Errorf("%q: got %v, want %s", tt.input, tok, tt.name)
+		}
+	}
+}
+
+func TestRawQuote(t *testing.T) {
+	src := "`a\\d+\n\"b\"` x"
+	toks := testParse([]byte(src))
+	if len(toks) != 3 || toks[0].Name != RAW_QUOTE || toks[0].Val != src[:10] || toks[1].Pos.Line != 2 {
+		t.Fatalf("got %v, want RAW_QUOTE followed by x on line 2", toks)
+	}
+	if s, err := toks[0].Unquote(); err != nil || s != "a\\d+\n\"b\"" {
+		t.Errorf("Unquote() = %q, %v", s, err)
+	}
+	if s := toks[0].Text(); s != "a\\d+\n\"b\"" {
+		t.Errorf("Text() = %q", s)
+	}
+
+	if tok := NewLexer([]byte("``")).NextToken(); tok.Name != RAW_QUOTE || tok.Text() != "" {
+		t.Errorf("got %v, want empty RAW_QUOTE", tok)
+	}
+
+	// Tokens that weren't produced by the lexer may not be quoted at all.
+	for _, val := range []string{"", "`", "abc", "`abc", "abc`", `"abc"`} {
+		tok := Token{Name: RAW_QUOTE, Val: val}
+		if s, err := tok.Unquote(); err == nil {
+			t.Errorf("%q: Unquote() = %q, want an error", val, s)
+		}
+		if s := tok.Text(); s != val {
+			t.Errorf("%q: Text() = %q", val, s)
+		}
+	}
+}
+
+func TestBlockQuote(t *testing.T) {
+	var tests = []struct {
+		input string
+		name  TokenName
+		text  string
+	}{
+		{`"a"`, QUOTE, "a"},
+		{`""`, QUOTE, ""},
+		{`"""a` + "\n" + `b"""`, BLOCK_QUOTE, "a\nb"},
+		{`"""it's "quoted" \n"""`, BLOCK_QUOTE, `it's "quoted" \n`},
+		{`""""""`, BLOCK_QUOTE, ""},
+	}
+	for _, tt := range tests {
+		toks := testParse([]byte(tt.input))
+		if len(toks) != 2 || toks[0].Name != tt.name || toks[0].Val != tt.input || toks[0].Text() != tt.text {
+			t.Errorf("%q: got %v, want %s with text %q", tt.input, toks, tt.name, tt.text)
+		}
+	}
+
+	for _, src := range []string{`"""a`, `"""a""`, `""""`} {
+		if tok := NewLexer([]byte(src)).NextToken(); tok.Name != ERROR || tok.Msg != "unterminated block string literal" {
+			t.Errorf("%q: got %v, want unterminated block string ERROR", src, tok)
+		}
+	}
+
+	toks := testParse([]byte(`"""a"""" "`))
+	if len(toks) != 3 || toks[0].Name != BLOCK_QUOTE || toks[1].Name != QUOTE || toks[1].Val != `" "` {
+		t.Errorf("got %v, want BLOCK_QUOTE ending at the first closing delimiter", toks)
+	}
+
+	// Tokens that weren't produced by the lexer may not be quoted at all.
+	for _, val := range []string{"", `"`, `"""`, `"""""`, `""" x`, `x """`, `"ab"`} {
+		tok := Token{Name: BLOCK_QUOTE, Val: val}
+		if s, err := tok.Unquote(); err == nil {
+			t.Errorf("%q: Unquote() = %q, want an error", val, s)
+		}
+		if s := tok.Text(); s != val {
+			t.Errorf("%q: Text() = %q", val, s)
+		}
+	}
+}
+
+func TestCharLiterals(t *testing.T) {
+	var tests = []struct {
+		input string
+		r     rune
+	}{
+		{`'a'`, 'a'},
+		{`'\n'`, '\n'},
+		{`'ä'`, 'ä'},
+		{`'\u00e4'`, 'ä'},
+		{`'\''`, '\''},
+		{`'"'`, '"'},
+		{`'本'`, '本'},
+	}
+	for _, tt := range tests {
+		toks := testParse([]byte(tt.input))
+		if len(toks) != 2 || toks[0].Name != CHAR || toks[0].Val != tt.input {
+			t.Errorf("%s: got %v, want a single CHAR", tt.input, toks)
+			continue
+		}
+		if r, err := toks[0].Rune(); err != nil || r != tt.r {
+			t.Errorf("%s: Rune() = %q, %v; want %q", tt.input, r, err, tt.r)
+		}
+	}
+
+	var errors = []struct {
+		input string
+		msg   string
+	}{
+		{`''`, "empty character literal"},
+		{`'a`, "unterminated character literal"},
+		{`'ab'`, "character literal has more than one character"},
+		{`'a\'b'`, "character literal has more than one character"},
+		{`'\nx'`, "character literal has more than one character"},
+		{"'ab\n'", "unterminated character literal"},
+		{`'a\'`, "unterminated character literal"},
+		{"'\n'", "unterminated character literal"},
+		{"'\r'", "unterminated character literal"},
+		{"'ab\r\n'", "unterminated character literal"},
+		{`'\q'`, "invalid escape sequence"},
+	}
+	for _, tt := range errors {
+		if tok := NewLexer([]byte(tt.input)).NextToken(); tok.Name != ERROR || tok.Msg != tt.msg {
+			t.Errorf("%q: got %v (%q), want ERROR with %q", tt.input, tok, tok.Msg, tt.msg)
+		}
+	}
+
+	// The whole of a literal with too many characters is skipped.
+	toks := Lex([]byte("x = 'ab' + y\n'cd"))
+	want := []Token{
+		{Name: IDENTIFIER, Val: "x", Pos: Position{0, 1, 1}},
+		{Name: EQUALS, Val: "=", Pos: Position{2, 1, 3}},
+		{Name: ERROR, Pos: Position{4, 1, 5}, Msg: "character literal has more than one character"},
+	}
+	if !TokensEqual(toks, want) {
+		t.Errorf("got %v, want %v", toks, want)
+	}
+	lex := NewLexer([]byte("x = 'ab' + y\n'cd"))
+	var names []TokenName
+	for tok := lex.NextToken(); tok.Name != EOF; tok = lex.NextToken() {
+		names = append(names, tok.Name)
+	}
+	if want := []TokenName{IDENTIFIER, EQUALS, ERROR, PLUS, IDENTIFIER, ERROR, IDENTIFIER}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+
+	// MaxTokenLen stops an overlong or unterminated literal without reading
+	// the rest of its line.
+	for _, src := range []string{"'ab\t' x", "'ab" + strings.Repeat("c", 1<<20) + " x", "'a" + strings.Repeat(`\'`, 1<<10) + "' x"} {
+		lex = NewLexerReaderWithOptions(iotest.OneByteReader(strings.NewReader(src)), Options{MaxTokenLen: 2})
+		var toks []Token
+		for tok := lex.NextToken(); tok.Name != EOF && len(toks) < 10; tok = lex.NextToken() {
+			toks = append(toks, tok)
+		}
+		if len(toks) != 2 || toks[0].Msg != "token too long" || toks[1].Val != "x" {
+			t.Errorf("%.10q...: got %v", src, toks)
+		}
+		if c := cap(lex.buf); c > 64<<10 {
+			t.Errorf("%.10q...: buffer grew to %d bytes", src, c)
+		}
+	}
+
+	// With char literals disabled, a single quote isn't special.
+	lex = NewLexerWithOptions([]byte("'a'"), Options{DisableCharLiterals: true})
+	if tok := lex.NextToken(); tok.Name != ERROR || tok.Msg != `unexpected character '\''` {
+		t.Errorf("got %v (%q), want unexpected character ERROR", tok, tok.Msg)
+	}
+}
+
+func TestSignificantNewlines(t *testing.T) {
+	src := []byte("a = 1 // one\n\n  \r\nb = 2\n")
+
+	lex := NewLexerWithOptions(src, Options{SignificantNewlines: true})
+	expected := []struct {
+		name TokenName
+		line int
+	}{
+		{IDENTIFIER, 1}, {EQUALS, 1}, {NUMBER, 1}, {COMMENT, 1}, {NEWLINE, 1},
+		{IDENTIFIER, 4}, {EQUALS, 4}, {NUMBER, 4}, {NEWLINE, 4},
+		{EOF, 5},
+	}
+	for i, e := range expected {
+		if tok := lex.NextToken(); tok.Name != e.name || tok.Pos.Line != e.line {
+			t.Errorf("token %d: got %v at %s, want %s on line %d", i, tok, tok.Position(), e.name, e.line)
+		}
+	}
+
+	// By default newlines are skipped.
+	for _, tok := range Lex(src) {
+		if tok.Name == NEWLINE {
+			t.Errorf("got %v without SignificantNewlines", tok)
+		}
+	}
+}
+
+func TestIndentation(t *testing.T) {
+	src := []byte("a\n  b\n\n    c\n  d\ne\n  f\n      g\n")
+	expected := []TokenName{
+		IDENTIFIER, NEWLINE,
+		INDENT, IDENTIFIER, NEWLINE,
+		INDENT, IDENTIFIER, NEWLINE,
+		DEDENT, IDENTIFIER, NEWLINE,
+		DEDENT, IDENTIFIER, NEWLINE,
+		INDENT, IDENTIFIER, NEWLINE,
+		INDENT, IDENTIFIER, NEWLINE,
+		DEDENT, DEDENT, EOF,
+	}
+	lex := NewLexerWithOptions(src, Options{Indentation: true})
+	for i, want := range expected {
+		if tok := lex.NextToken(); tok.Name != want {
+			t.Fatalf("token %d: got %v, want %s", i, tok, want)
+		}
+	}
+
+	// A dedent to two levels at once.
+	lex = NewLexerWithOptions([]byte("a\n b\n  c\nd"), Options{Indentation: true})
+	var names []TokenName
+	for tok := lex.NextToken(); tok.Name != EOF; tok = lex.NextToken() {
+		names = append(names, tok.Name)
+	}
+	if fmt.Sprint(names) != "[IDENTIFIER NEWLINE INDENT IDENTIFIER NEWLINE INDENT IDENTIFIER NEWLINE DEDENT DEDENT IDENTIFIER]" {
+		t.Errorf("got %v", names)
+	}
+	// Lines holding only a comment, at any indentation, leave the open
+	// levels alone.
+	src = []byte("a:\n  b\n// top\n      // deep\n  c # x\nd\n")
+	for i, opts := range []Options{{Indentation: true}, {Indentation: true, SkipComments: true}, {Indentation: true, LineCommentPrefixes: []string{"//", "#"}}} {
+		lex = NewLexerWithOptions(src, opts)
+		names = names[:0]
+		for tok := lex.NextToken(); tok.Name != EOF; tok = lex.NextToken() {
+			names = append(names, tok.Name)
+		}
+		want := "[IDENTIFIER COLON NEWLINE INDENT IDENTIFIER NEWLINE COMMENT COMMENT IDENTIFIER POUND IDENTIFIER NEWLINE DEDENT IDENTIFIER NEWLINE]"
+		if opts.SkipComments {
+			want = "[IDENTIFIER COLON NEWLINE INDENT IDENTIFIER NEWLINE IDENTIFIER POUND IDENTIFIER NEWLINE DEDENT IDENTIFIER NEWLINE]"
+		} else if opts.LineCommentPrefixes != nil {
+			want = "[IDENTIFIER COLON NEWLINE INDENT IDENTIFIER NEWLINE COMMENT COMMENT IDENTIFIER COMMENT NEWLINE DEDENT IDENTIFIER NEWLINE]"
+		}
+		if fmt.Sprint(names) != want {
+			t.Errorf("options %d: got %v, want %s", i, names, want)
+		}
+	}
+}
+
+func TestIndentationErrors(t *testing.T) {
+	var tests = []struct {
+		input string
+		msg   string
+	}{
+		{"a\n    b\n  c\n", "unindent does not match any outer indentation level"},
+		{"a\n \tb\n", "mixed tabs and spaces in indentation"},
+	}
+	for _, tt := range tests {
+		lex := NewLexerWithOptions([]byte(tt.input), Options{Indentation: true})
+		var errs []Token
+		for _, tok := range lex.Tokens() {
+			if tok.Name == ERROR {
+				errs = append(errs, tok)
+			}
+		}
+		if len(errs) != 1 || errs[0].Msg != tt.msg || errs[0].Pos.Col != 1 {
+			t.Errorf("%q: got errors %v, want %q at column 1", tt.input, errs, tt.msg)
+		}
+	}
+}
+
+func TestParseTokenName(t *testing.T) {
+	for n := range tokenNames {
+		if got, ok := ParseTokenName(tokenNames[n]); !ok || got != TokenName(n) {
+			t.Errorf("ParseTokenName(%q) = %v, %v; want %v", tokenNames[n], got, ok, TokenName(n))
+		}
+	}
+	if n, ok := ParseTokenName("L_PAREN"); !ok || n != L_PAREN {
+		t.Errorf("ParseTokenName(L_PAREN) = %v, %v", n, ok)
+	}
+	for _, s := range []string{"", "l_paren", "TokenName(3)"} {
+		if n, ok := ParseTokenName(s); ok {
+			t.Errorf("ParseTokenName(%q) = %v, want not found", s, n)
+		}
+	}
+}
+
+// syntheticInput returns a TableGen-like input of roughly n bytes, mostly ASCII
+// with the occasional multibyte rune.
+func syntheticInput(n int) []byte {
+	var b bytes.Buffer
+	for i := 0; b.Len() < n; i++ {
+		fmt.Fprintf(&b, "def Inst%d : Instruction<\"name_%d\", [(set GPR:$dst, (add GPR:$a, %d))]>; // ä\n", i, i, i)
+		if i%10 == 0 {
+			fmt.Fprintf(&b, "  let Size = 0x%X; /* 本 */\n", i)
+		}
+	}
+	return b.Bytes()
+}
+
+func BenchmarkLexSynthetic(b *testing.B) {
+	buf := syntheticInput(1 << 20)
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lex := NewLexer(buf)
+		for lex.NextToken().Name != EOF {
+		}
+	}
+}
+
+func BenchmarkLexSyntheticBytes(b *testing.B) {
+	buf := syntheticInput(1 << 20)
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lex := NewLexerWithOptions(buf, Options{ByteValues: true})
+		for lex.NextToken().Name != EOF {
+		}
+	}
+}
+
+func repeatedIdentInput(n int) []byte {
+	var b bytes.Buffer
+	for b.Len() < n {
+		b.WriteString("let Defs = [GPR, FPR, GPR]; def ADD : Inst<GPR, GPR>;\n")
+	}
+	return b.Bytes()
+}
+
+func BenchmarkLexRepeatedIdents(b *testing.B) {
+	buf := repeatedIdentInput(1 << 20)
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lex := NewLexerWithOptions(buf, Options{Keywords: TableGenKeywords})
+		for lex.NextToken().Name != EOF {
+		}
+	}
+}
+
+func BenchmarkLexRepeatedIdentsInterned(b *testing.B) {
+	buf := repeatedIdentInput(1 << 20)
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lex := NewLexerWithOptions(buf, Options{Keywords: TableGenKeywords, InternIdentifiers: true})
+		for lex.NextToken().Name != EOF {
+		}
+	}
+}
+
+// BenchmarkLexRepeatedIdentsReader is like the benchmarks above for a lexer
+// reading from an io.Reader, where interning saves most allocations.
+func BenchmarkLexRepeatedIdentsReader(b *testing.B) {
+	buf := repeatedIdentInput(1 << 20)
+	for _, intern := range []bool{false, true} {
+		b.Run(fmt.Sprintf("intern=%t", intern), func(b *testing.B) {
+			b.SetBytes(int64(len(buf)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				lex := NewLexerReaderWithOptions(bytes.NewReader(buf), Options{Keywords: TableGenKeywords, InternIdentifiers: intern})
+				for lex.NextToken().Name != EOF {
+				}
+			}
+		})
+	}
+}
+
+func TestWhitespacePositions(t *testing.T) {
+	src := []byte("a \t b\r\n\t\t c\n\n  \td\r\re \x00\t\x00 本\t \t ä\n\t")
+	tests := []Options{
+		{},
+		{TabWidth: 4},
+		{TabWidth: 8, Nul: NulWhitespace},
+		{Nul: NulWhitespace, SignificantNewlines: true},
+	}
+	for i, opts := range tests {
+		opts.TrackRuneOffsets = true
+		m := NewLineMap(src)
+		m.TabWidth = opts.TabWidth
+		toks := NewLexerWithOptions(src, opts).Tokens()
+		for _, tok := range toks {
+			line, col := m.LineCol(tok.Pos.Offset)
+			if tok.Pos.Line != line || tok.Pos.Col != col {
+				t.Errorf("%d: %v is at %d:%d, want %d:%d", i, tok, tok.Pos.Line, tok.Pos.Col, line, col)
+			}
+			if want := utf8.RuneCount(src[:tok.Pos.Offset]); tok.RuneOffset != want {
+				t.Errorf("%d: %v has RuneOffset %d, want %d", i, tok, tok.RuneOffset, want)
+			}
+		}
+		// The fast path in skipNontokens only applies to input already in
+		// buf, so reading a byte at a time must give the same result.
+		lex := NewLexerReaderWithOptions(iotest.OneByteReader(bytes.NewReader(src)), opts)
+		if rd := lex.Tokens(); !reflect.DeepEqual(rd, toks) {
+			t.Errorf("%d: reader got %v, want %v", i, rd, toks)
+		}
+	}
+}
+
+func indentedInput(n int) []byte {
+	var b bytes.Buffer
+	for i := 0; b.Len() < n; i++ {
+		indent := strings.Repeat("\t", 4+i%8) + strings.Repeat(" ", 4+i%32)
+		fmt.Fprintf(&b, "%slet X%d = %d;\n\n%s\t  \r\n", indent, i, i, indent)
+	}
+	return b.Bytes()
+}
+
+func BenchmarkLexIndented(b *testing.B) {
+	buf := indentedInput(1 << 20)
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lex := NewLexer(buf)
+		for lex.NextToken().Name != EOF {
+		}
+	}
+}
+
+func TestInternIdentifiers(t *testing.T) {
+	src := []byte("def GPR GPR def \"GPR\"")
+	want := NewLexerWithOptions(src, Options{Keywords: TableGenKeywords}).Tokens()
+	got := NewLexerWithOptions(src, Options{Keywords: TableGenKeywords, InternIdentifiers: true}).Tokens()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	same := func(a, b string) bool {
+		return unsafe.StringData(a) == unsafe.StringData(b)
+	}
+	if !same(got[0].Val, got[3].Val) {
+		t.Errorf("keyword %q not interned", got[0].Val)
+	}
+	if !same(got[1].Val, got[2].Val) {
+		t.Errorf("identifier %q not interned", got[1].Val)
+	}
+	if same(want[1].Val, want[2].Val) {
+		t.Errorf("identifier %q interned without the option", want[1].Val)
+	}
+}
+
+func TestByteValues(t *testing.T) {
+	src := []byte(`def x = "a\tb" 0x1F`)
+	want := Lex(src)
+	got := NewLexerWithOptions(src, Options{ByteValues: true, Keywords: TableGenKeywords}).Tokens()
+	if len(got) != len(want) {
+		t.Fatalf("expected %d tokens, got %d", len(want), len(got))
+	}
+	for i, tok := range got {
+		if tok.Val != "" {
+			t.Errorf("token %d: Val = %q, want it empty", i, tok.Val)
+		}
+		if tok.ValString() != want[i].Val || tok.Pos.Offset != want[i].Pos.Offset {
+			t.Errorf("token %d: got %v, want %v", i, tok, want[i])
+		}
+	}
+	if got[0].Name != DEF {
+		t.Errorf("keyword lookup: got %v, want DEF", got[0].Name)
+	}
+	if s := got[3].Text(); s != "a\tb" {
+		t.Errorf("Text = %q, want %q", s, "a\tb")
+	}
+	if n, err := got[4].Int(); err != nil || n != 0x1F {
+		t.Errorf("Int = %d, %v, want 31", n, err)
+	}
+
+	// Appending to a token's bytes must not clobber the input.
+	_ = append(got[1].Bytes, '!')
+	if string(src) != `def x = "a\tb" 0x1F` {
+		t.Errorf("input modified: %q", src)
+	}
+}
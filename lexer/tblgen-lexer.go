@@ -2,10 +2,22 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
 	"time"
+	"unicode"
 	"unicode/utf8"
 )
 
@@ -14,14 +26,25 @@ type TokenName int
 
 // Values for TokenName
 const (
-	// Special tokens
-	ERROR TokenName = iota
+	// Special tokens. NONE is the zero value and is never returned by the
+	// lexer; it marks "no token", e.g. for runes that aren't operators in
+	// opTable.
+	NONE TokenName = iota
+	ERROR
 	EOF
 
 	COMMENT
 	IDENTIFIER
 	NUMBER
 	QUOTE
+	RAW_QUOTE
+	BLOCK_QUOTE
+	CHAR
+	NEWLINE
+	INDENT
+	DEDENT
+	WHITESPACE
+	NUL
 
 	// Operators
 	PLUS
@@ -48,15 +71,54 @@ const (
 	L_BRACKET
 	R_BRACKET
 	EQUALS
+	AT
+	TILDE
+
+	// Two-character operators
+	EQ_EQ
+	NOT_EQ
+	LE
+	GE
+	AND_AND
+	OR_OR
+	COLON_EQ
+	ARROW
+	PLUS_EQ
+	MINUS_EQ
+	COLON_COLON
+	RANGE
+	SHL
+	SHR
+
+	// TableGen keywords. These are only produced by lexers configured with a
+	// keyword table such as TableGenKeywords.
+	CLASS
+	DEF
+	DEFM
+	FIELD
+	FOREACH
+	IN
+	INCLUDE
+	LET
+	MULTICLASS
 )
 
 var tokenNames = [...]string{
+	NONE:        "NONE",
 	ERROR:       "ERROR",
 	EOF:         "EOF",
 	COMMENT:     "COMMENT",
 	IDENTIFIER:  "IDENTIFIER",
 	NUMBER:      "NUMBER",
 	QUOTE:       "QUOTE",
+	RAW_QUOTE:   "RAW_QUOTE",
+	BLOCK_QUOTE: "BLOCK_QUOTE",
+	CHAR:        "CHAR",
+	NEWLINE:     "NEWLINE",
+	INDENT:      "INDENT",
+	DEDENT:      "DEDENT",
+	WHITESPACE:  "WHITESPACE",
+	NUL:         "NUL",
 	PLUS:        "PLUS",
 	MINUS:       "MINUS",
 	MULTIPLY:    "MULTIPLY",
@@ -81,27 +143,495 @@ var tokenNames = [...]string{
 	L_BRACKET:   "L_BRACKET",
 	R_BRACKET:   "R_BRACKET",
 	EQUALS:      "EQUALS",
+	AT:          "AT",
+	TILDE:       "TILDE",
+	EQ_EQ:       "EQ_EQ",
+	NOT_EQ:      "NOT_EQ",
+	LE:          "LE",
+	GE:          "GE",
+	AND_AND:     "AND_AND",
+	OR_OR:       "OR_OR",
+	COLON_EQ:    "COLON_EQ",
+	ARROW:       "ARROW",
+	PLUS_EQ:     "PLUS_EQ",
+	MINUS_EQ:    "MINUS_EQ",
+	COLON_COLON: "COLON_COLON",
+	RANGE:       "RANGE",
+	SHL:         "SHL",
+	SHR:         "SHR",
+	CLASS:       "CLASS",
+	DEF:         "DEF",
+	DEFM:        "DEFM",
+	FIELD:       "FIELD",
+	FOREACH:     "FOREACH",
+	IN:          "IN",
+	INCLUDE:     "INCLUDE",
+	LET:         "LET",
+	MULTICLASS:  "MULTICLASS",
+}
+
+// TableGenKeywords is a keyword table for the TableGen language, for use with
+// NewLexerWithKeywords.
+var TableGenKeywords = map[string]TokenName{
+	"class":      CLASS,
+	"def":        DEF,
+	"defm":       DEFM,
+	"field":      FIELD,
+	"foreach":    FOREACH,
+	"in":         IN,
+	"include":    INCLUDE,
+	"let":        LET,
+	"multiclass": MULTICLASS,
+}
+
+// String returns the mnemonic name of n, or "TokenName(n)" if n isn't a known
+// token name.
+func (n TokenName) String() string {
+	if n >= 0 && int(n) < len(tokenNames) && tokenNames[n] != "" {
+		return tokenNames[n]
+	}
+	return "TokenName(" + strconv.Itoa(int(n)) + ")"
+}
+
+var (
+	tokenNamesOnce  sync.Once
+	tokenNameLookup map[string]TokenName
+)
+
+// ParseTokenName returns the TokenName whose mnemonic name is s, such as
+// L_PAREN for "L_PAREN". It's the inverse of TokenName.String for known names.
+func ParseTokenName(s string) (TokenName, bool) {
+	// The reverse lookup table is built from tokenNames on first use.
+	tokenNamesOnce.Do(func() {
+		tokenNameLookup = make(map[string]TokenName, len(tokenNames))
+		for n, name := range tokenNames {
+			if name != "" {
+				tokenNameLookup[name] = TokenName(n)
+			}
+		}
+	})
+	n, ok := tokenNameLookup[s]
+	return n, ok
+}
+
+// TokenCategory is a coarse classification of token names, for clients such
+// as syntax highlighters that don't need to tell every operator apart.
+type TokenCategory int
+
+// Values for TokenCategory
+const (
+	CatNone TokenCategory = iota
+	CatIdentifier
+	CatKeyword
+	CatNumber
+	CatString
+	CatOperator
+	CatComment
+	CatWhitespace
+	CatError
+	CatEOF
+)
+
+var tokenCategoryNames = [...]string{
+	CatNone:       "CatNone",
+	CatIdentifier: "CatIdentifier",
+	CatKeyword:    "CatKeyword",
+	CatNumber:     "CatNumber",
+	CatString:     "CatString",
+	CatOperator:   "CatOperator",
+	CatComment:    "CatComment",
+	CatWhitespace: "CatWhitespace",
+	CatError:      "CatError",
+	CatEOF:        "CatEOF",
+}
+
+// String returns the name of c, or "TokenCategory(c)" if c isn't a known
+// category.
+func (c TokenCategory) String() string {
+	if c >= 0 && int(c) < len(tokenCategoryNames) {
+		return tokenCategoryNames[c]
+	}
+	return "TokenCategory(" + strconv.Itoa(int(c)) + ")"
+}
+
+// tokenCategories maps each token name to its category. Names without an
+// entry, including NONE, are in CatNone.
+var tokenCategories = [...]TokenCategory{
+	ERROR:       CatError,
+	EOF:         CatEOF,
+	COMMENT:     CatComment,
+	IDENTIFIER:  CatIdentifier,
+	NUMBER:      CatNumber,
+	QUOTE:       CatString,
+	RAW_QUOTE:   CatString,
+	BLOCK_QUOTE: CatString,
+	CHAR:        CatString,
+	NEWLINE:     CatWhitespace,
+	INDENT:      CatWhitespace,
+	DEDENT:      CatWhitespace,
+	WHITESPACE:  CatWhitespace,
+	NUL:         CatWhitespace,
+
+	PLUS:        CatOperator,
+	MINUS:       CatOperator,
+	MULTIPLY:    CatOperator,
+	DIVIDE:      CatOperator,
+	PERIOD:      CatOperator,
+	BACKSLASH:   CatOperator,
+	COLON:       CatOperator,
+	PERCENT:     CatOperator,
+	PIPE:        CatOperator,
+	EXCLAMATION: CatOperator,
+	QUESTION:    CatOperator,
+	POUND:       CatOperator,
+	AMPERSAND:   CatOperator,
+	SEMI:        CatOperator,
+	COMMA:       CatOperator,
+	L_PAREN:     CatOperator,
+	R_PAREN:     CatOperator,
+	L_ANG:       CatOperator,
+	R_ANG:       CatOperator,
+	L_BRACE:     CatOperator,
+	R_BRACE:     CatOperator,
+	L_BRACKET:   CatOperator,
+	R_BRACKET:   CatOperator,
+	EQUALS:      CatOperator,
+	AT:          CatOperator,
+	TILDE:       CatOperator,
+
+	EQ_EQ:       CatOperator,
+	NOT_EQ:      CatOperator,
+	LE:          CatOperator,
+	GE:          CatOperator,
+	AND_AND:     CatOperator,
+	OR_OR:       CatOperator,
+	COLON_EQ:    CatOperator,
+	ARROW:       CatOperator,
+	PLUS_EQ:     CatOperator,
+	MINUS_EQ:    CatOperator,
+	COLON_COLON: CatOperator,
+	RANGE:       CatOperator,
+	SHL:         CatOperator,
+	SHR:         CatOperator,
+
+	CLASS:      CatKeyword,
+	DEF:        CatKeyword,
+	DEFM:       CatKeyword,
+	FIELD:      CatKeyword,
+	FOREACH:    CatKeyword,
+	IN:         CatKeyword,
+	INCLUDE:    CatKeyword,
+	LET:        CatKeyword,
+	MULTICLASS: CatKeyword,
+}
+
+// Category returns the category of n, or CatNone if n isn't a known token
+// name.
+func (n TokenName) Category() TokenCategory {
+	if n >= 0 && int(n) < len(tokenCategories) {
+		return tokenCategories[n]
+	}
+	return CatNone
 }
 
 // Token represents a single token in the input stream.
 // Name: mnemonic name (numeric).
-// Val: string value of the token from the original stream.
-// Pos: position - offset from beginning of stream.
+// Val: string value of the token from the original stream. Unless the lexer
+// reads from an io.Reader, a short Val may share memory with up to 4KB of the
+// surrounding input, which is kept alive as long as Val is; use strings.Clone
+// to keep a value around longer than the rest of the tokens.
+// Pos: position of the token's first rune in the stream.
+// RuneOffset: with the TrackRuneOffsets option, the offset of the token in
+// runes (rather than bytes) from the beginning of the stream.
+// Msg: for ERROR tokens, a description of what went wrong.
+// Unit: for NUMBER tokens with the UnitSuffixes option, the unit following
+// the number, if any.
+// Bytes: with the ByteValues option, the value of the token in place of Val.
+// It aliases the lexer's input, so it's only valid while that buffer is alive
+// and unmodified.
+// Truncated: with the MarkTruncated option, whether the token runs up to the
+// end of the input, or the lexer looked ahead to it, where more input could
+// have made the token longer or different. So "foo" could become "food", and
+// "1" in "1e" could become "1e5", but "(" can't change. It tells a caller
+// lexing input in chunks which token to rescan.
 type Token struct {
-	Name TokenName
-	Val  string
-	Pos  int
+	Name       TokenName
+	Val        string
+	Pos        Position
+	RuneOffset int
+	Msg        string
+	Unit       string
+	Bytes      []byte
+	Truncated  bool
 }
 
 func (tok Token) String() string {
-	return fmt.Sprintf("Token{%s, '%s', %d}", tokenNames[tok.Name], tok.Val, tok.Pos)
+	return fmt.Sprintf("Token{%s, '%s', %d}", tok.Name, tok.ValString(), tok.Pos.Offset)
+}
+
+// DumpTokens writes toks to w as a table with a row for each token, giving its
+// name, quoted value, position (as line:col and byte offset) and any error
+// message, for debugging. Quoting the values makes whitespace and invalid
+// UTF-8 visible. Errors writing to w are ignored.
+func DumpTokens(w io.Writer, toks []Token) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tVALUE\tPOS\tOFFSET")
+	for _, tok := range toks {
+		fmt.Fprintf(tw, "%s\t%q\t%s\t%d", tok.Name, tok.ValString(), tok.Position(), tok.Pos.Offset)
+		if tok.Msg != "" {
+			fmt.Fprintf(tw, "\t%s", tok.Msg)
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+}
+
+// Position is a position in the input. It replaces the bare byte offset that
+// Token.Pos used to be: code using that should use Token.Pos.Offset instead.
+// Offset: byte offset from the beginning of the stream, for slicing the input.
+// Line, Col: 1-based line and column, counting columns in runes.
+type Position struct {
+	Offset int
+	Line   int
+	Col    int
+}
+
+// String returns the position formatted as "line:col".
+func (pos Position) String() string {
+	return fmt.Sprintf("%d:%d", pos.Line, pos.Col)
+}
+
+// ValString returns the value of the token as a string, whether it's held in
+// Val or (with the ByteValues option) in Bytes. The latter allocates a copy.
+func (tok Token) ValString() string {
+	if tok.Bytes != nil {
+		return string(tok.Bytes)
+	}
+	return tok.Val
+}
+
+// CleanNumber returns the value of a NUMBER token with any '_' digit
+// separators removed, ready to be passed to strconv. It doesn't check where
+// the separators are; Int and Float do.
+func (tok Token) CleanNumber() string {
+	return strings.ReplaceAll(tok.ValString(), "_", "")
+}
+
+// validSeparators reports whether every '_' in the number s sits between two
+// digits, as the lexer requires, and none is in an exponent.
+func validSeparators(s string) bool {
+	_, s = splitSign(s)
+	isDigitOf, decimal := isDigit, true
+	if len(s) > 2 && s[0] == '0' {
+		if f := prefixDigitClass(rune(s[1])); f != nil {
+			isDigitOf, decimal, s = f, false, s[2:]
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '_' {
+			continue
+		}
+		if i == 0 || i == len(s)-1 || !isDigitOf(rune(s[i-1])) || !isDigitOf(rune(s[i+1])) {
+			return false
+		}
+		if decimal && strings.ContainsAny(s[:i], "eE") {
+			return false
+		}
+	}
+	return true
+}
+
+// Int parses the value of a NUMBER token as an int64. A "0x", "0o" or "0b"
+// prefix selects base 16, 8 or 2; anything else is parsed as decimal. The
+// prefix may be preceded by a sign (see the SignedNumbers option).
+func (tok Token) Int() (int64, error) {
+	if tok.Name != NUMBER {
+		return 0, fmt.Errorf("cannot convert %s token to a number", tok.Name)
+	}
+	if !validSeparators(tok.ValString()) {
+		return 0, &strconv.NumError{Func: "ParseInt", Num: tok.ValString(), Err: strconv.ErrSyntax}
+	}
+	sign, val := splitSign(tok.CleanNumber())
+	base := 10
+	if len(val) > 2 && val[0] == '0' {
+		switch val[1] {
+		case 'x', 'X':
+			base = 16
+		case 'o', 'O':
+			base = 8
+		case 'b', 'B':
+			base = 2
+		}
+		if base != 10 {
+			val = val[2:]
+		}
+	}
+	return strconv.ParseInt(sign+val, base, 64)
+}
+
+// splitSign splits a leading '+' or '-' off a number.
+func splitSign(s string) (sign, rest string) {
+	if len(s) > 0 && (s[0] == '+' || s[0] == '-') {
+		return s[:1], s[1:]
+	}
+	return "", s
+}
+
+// Float parses the value of a NUMBER token as a float64. Integers with a base
+// prefix are accepted too.
+func (tok Token) Float() (float64, error) {
+	if tok.Name != NUMBER {
+		return 0, fmt.Errorf("cannot convert %s token to a number", tok.Name)
+	}
+	if !validSeparators(tok.ValString()) {
+		return 0, &strconv.NumError{Func: "ParseFloat", Num: tok.ValString(), Err: strconv.ErrSyntax}
+	}
+	val := tok.CleanNumber()
+	if _, v := splitSign(val); len(v) > 2 && v[0] == '0' && prefixDigitClass(rune(v[1])) != nil {
+		n, err := tok.Int()
+		return float64(n), err
+	}
+	return strconv.ParseFloat(val, 64)
+}
+
+// IsValidNumber reports whether tok is a NUMBER token whose value is a
+// well-formed number for Int (with a base prefix) or Float (without one), such
+// as one built by hand or by a custom scanner. Values that are well-formed but
+// out of range are valid, and so are the words for infinity and NaN that Float
+// accepts.
+func (tok Token) IsValidNumber() bool {
+	if tok.Name != NUMBER {
+		return false
+	}
+	_, err := tok.Float()
+	return err == nil || errors.Is(err, strconv.ErrRange)
+}
+
+// Text returns the decoded string for a QUOTE, RAW_QUOTE or BLOCK_QUOTE token
+// and the raw value for any other token. A QUOTE token that can't be decoded
+// also yields its raw value.
+func (tok Token) Text() string {
+	if tok.Name == QUOTE || tok.Name == RAW_QUOTE || tok.Name == BLOCK_QUOTE {
+		if s, err := tok.Unquote(); err == nil {
+			return s
+		}
+	}
+	return tok.ValString()
+}
+
+// Unquote returns the decoded value of a QUOTE token, with the surrounding
+// quotes removed and escape sequences replaced by the characters they stand
+// for. For RAW_QUOTE and BLOCK_QUOTE tokens only the delimiters are removed.
+func (tok Token) Unquote() (string, error) {
+	val := tok.ValString()
+	switch tok.Name {
+	case QUOTE:
+		return unquote(val)
+	case RAW_QUOTE:
+		if n := len(val); n < 2 || val[0] != '`' || val[n-1] != '`' {
+			return "", fmt.Errorf("invalid raw string %q", val)
+		}
+		return val[1 : len(val)-1], nil
+	case BLOCK_QUOTE:
+		if len(val) < 6 || !strings.HasPrefix(val, `"""`) || !strings.HasSuffix(val, `"""`) {
+			return "", fmt.Errorf("invalid block string %q", val)
+		}
+		return val[3 : len(val)-3], nil
+	}
+	return "", fmt.Errorf("cannot unquote %s token", tok.Name)
+}
+
+// Rune returns the decoded character of a CHAR token.
+func (tok Token) Rune() (rune, error) {
+	if tok.Name != CHAR {
+		return 0, fmt.Errorf("cannot convert %s token to a rune", tok.Name)
+	}
+	val := tok.ValString()
+	s, err := unquote(val)
+	if err != nil {
+		return 0, err
+	}
+	r, w := utf8.DecodeRuneInString(s)
+	if w == 0 || w != len(s) {
+		return 0, fmt.Errorf("invalid character literal %s", val)
+	}
+	return r, nil
+}
+
+// Position returns the token's position formatted as "line:col", the same as
+// tok.Pos.String().
+func (tok Token) Position() string {
+	return tok.Pos.String()
+}
+
+// Equal reports whether tok and other have the same name and value, ignoring
+// their positions.
+func (tok Token) Equal(other Token) bool {
+	return tok.Name == other.Name && tok.ValString() == other.ValString()
+}
+
+// EqualPos is like Equal, but also requires the tokens to have the same Pos.
+func (tok Token) EqualPos(other Token) bool {
+	return tok.Equal(other) && tok.Pos == other.Pos
+}
+
+// TokensEqual reports whether a and b have the same length and their tokens
+// are pairwise Equal.
+func TokensEqual(a, b []Token) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// LineMap converts byte offsets in an input to line and column numbers, for
+// resolving token positions lazily when reporting errors.
+type LineMap struct {
+	// TabWidth sets the tab stops for columns, as for Options.TabWidth. It's
+	// only needed to match a lexer that has that option set.
+	TabWidth int
+
+	// Encoding is the encoding of the input, as for Options.Encoding, which
+	// columns are counted in runes of.
+	Encoding Encoding
+
+	buf []byte
+
+	// Offsets of the first byte of each line.
+	lines []int
+}
+
+// NewLineMap returns a LineMap for buf. buf must not be modified while the
+// LineMap is in use.
+func NewLineMap(buf []byte) *LineMap {
+	m := &LineMap{buf: buf, lines: []int{0}}
+	for i := range buf {
+		if isLineBreak(buf, i) {
+			m.lines = append(m.lines, i+1)
+		}
+	}
+	return m
 }
 
-func makeErrorToken(pos int) Token {
-	return Token{ERROR, "", pos}
+// LineCol returns the 1-based line and column of the byte offset pos, counting
+// columns in runes (and tab stops, with TabWidth) as the lexer does. Offsets
+// out of range are clamped to the input.
+func (m *LineMap) LineCol(pos int) (line, col int) {
+	if pos < 0 {
+		pos = 0
+	} else if pos > len(m.buf) {
+		pos = len(m.buf)
+	}
+	i := sort.Search(len(m.lines), func(i int) bool { return m.lines[i] > pos }) - 1
+	return i + 1, column(toUTF8(m.buf[m.lines[i]:pos], m.Encoding), m.TabWidth)
 }
 
-// Operator table for lookups.
+// Operator table for lookups. Runes that aren't operators map to NONE.
 var opTable = [...]TokenName{
 	'+':  PLUS,
 	'-':  MINUS,
@@ -127,6 +657,257 @@ var opTable = [...]TokenName{
 	'[':  L_BRACKET,
 	']':  R_BRACKET,
 	'=':  EQUALS,
+	'@':  AT,
+	'~':  TILDE,
+}
+
+// compoundOp is a two-character operator, described by its second character.
+type compoundOp struct {
+	second rune
+	name   TokenName
+}
+
+// Two-character operator table, indexed by the first character. Only runes
+// with an entry here can begin a two-character operator.
+var compoundOpTable = [...][]compoundOp{
+	'=': {{'=', EQ_EQ}},
+	'!': {{'=', NOT_EQ}},
+	'<': {{'=', LE}, {'<', SHL}},
+	'>': {{'=', GE}, {'>', SHR}},
+	'&': {{'&', AND_AND}},
+	'|': {{'|', OR_OR}},
+	':': {{'=', COLON_EQ}, {':', COLON_COLON}},
+	'-': {{'>', ARROW}, {'=', MINUS_EQ}},
+	'+': {{'=', PLUS_EQ}},
+	'.': {{'.', RANGE}},
+}
+
+// Options configures optional lexer behavior. The zero value gives the
+// behavior of NewLexer.
+type Options struct {
+	// SkipComments makes the lexer discard comments like whitespace instead of
+	// returning them as COMMENT tokens.
+	SkipComments bool
+
+	// Skip lists token names that NextToken drops instead of returning, so
+	// that, for example, a lexer with EmitWhitespace can still give some
+	// callers only the significant tokens. Setting Skip[COMMENT] is the same
+	// as SkipComments. EOF and ERROR tokens are never skipped.
+	Skip map[TokenName]bool
+
+	// Keywords maps identifier text to the token name returned for it instead
+	// of IDENTIFIER.
+	Keywords map[string]TokenName
+
+	// CaseInsensitiveKeywords makes keywords match regardless of case, so
+	// that "SELECT", "select" and "Select" are the same keyword. The keys in
+	// Keywords must then be lower case. Val keeps the original spelling.
+	CaseInsensitiveKeywords bool
+
+	// Operators adds single-character operators to the built-in ones, or
+	// overrides them: a rune found in Operators is returned as a token with
+	// the name Operators[r], or isn't an operator at all if that is NONE.
+	// The names may be ones the caller defines beyond the lexer's own. A
+	// rune in Operators is always a token on its own, so overriding '/', for
+	// example, also stops it from starting comments.
+	Operators map[rune]TokenName
+
+	// DoubledOperators maps a rune to the name of the token returned when it
+	// appears twice in a row, as in "**" or "<<". This takes precedence over
+	// the built-in two-character operators and "//" comments, and works for
+	// any rune, whether or not it's an operator on its own. A third
+	// occurrence starts a new token, so "<<<" with '<' mapped to SHL is SHL
+	// followed by L_ANG. In ModeAngleBrackets, ">>" is never doubled.
+	DoubledOperators map[rune]TokenName
+
+	// DisableCharLiterals turns off lexing of 'c' character literals, so that
+	// a single quote is no longer special.
+	DisableCharLiterals bool
+
+	// SignificantNewlines makes the lexer return a NEWLINE token for line
+	// breaks instead of skipping them. A "\r\n" pair is a single line break,
+	// as is a lone '\r'. A run of blank lines produces a single NEWLINE,
+	// unless EmitWhitespace is also set.
+	SignificantNewlines bool
+
+	// EmitWhitespace makes the lexer return each run of whitespace between
+	// tokens as a WHITESPACE token instead of skipping it. Together with
+	// comments, this lets the input be reconstructed exactly by concatenating
+	// the values of all tokens (except with the Indentation option, where
+	// the indentation of a line is only part of the INDENT token, if any).
+	EmitWhitespace bool
+
+	// NestedComments allows block comments to nest, as in
+	// "/* outer /* inner */ still outer */". Otherwise the first "*/" ends
+	// the comment.
+	NestedComments bool
+
+	// LineCommentPrefixes, if not nil, replaces "//" as the set of strings
+	// that start a comment running to the end of the line, for example
+	// []string{"#", ";"}. An operator such as '#' that starts a comment is
+	// then no longer returned as a token of its own. Block comments are
+	// unaffected.
+	LineCommentPrefixes []string
+
+	// CollectStats makes the lexer count the tokens it returns by name; see
+	// Stats.
+	CollectStats bool
+
+	// IsIdentStart and IsIdentCont, if not nil, replace the default rules
+	// for which runes can start and continue an identifier (see isIdentStart
+	// and isIdentCont). IsIdentStart takes precedence over operators, so for
+	// example it can let identifiers start with '@'.
+	IsIdentStart func(rune) bool
+	IsIdentCont  func(rune) bool
+
+	// SignedNumbers lets a '+' or '-' directly followed by a number be part
+	// of the NUMBER token, as in "[-3, +4]". As "a -3" is usually a
+	// subtraction, this is only done at the start of the input and after the
+	// tokens listed in SignedNumberAfter (other than comments and
+	// whitespace). If SignedNumberAfter is nil, they are opening brackets,
+	// COMMA, COLON, SEMI, EQUALS, NEWLINE and INDENT.
+	SignedNumbers     bool
+	SignedNumberAfter []TokenName
+
+	// AutoSemi inserts a SEMI token at the end of each line whose last token
+	// (other than comments) could end a statement, as Go and JavaScript do,
+	// and likewise at the end of the input. These are the tokens listed in
+	// AutoSemiAfter or, if it's nil, identifiers, numbers, string and
+	// character literals and closing brackets. An inserted SEMI has an empty
+	// value and the position of the line break, which is still lexed as
+	// usual afterwards.
+	AutoSemi      bool
+	AutoSemiAfter []TokenName
+
+	// UnitSuffixes lets a decimal NUMBER token be directly followed by a
+	// unit made of letters, as in "10ms" or "1.5GHz". The unit is stored in
+	// Token.Unit rather than Val, and isn't lexed as a separate IDENTIFIER.
+	UnitSuffixes bool
+
+	// SkipShebang makes the lexer skip a "#!" line at the start of the input
+	// (after any byte order mark, which is always skipped), as found in
+	// scripts, whatever comment syntax is configured.
+	SkipShebang bool
+
+	// DottedIdentifiers makes a qualified name like "foo.bar.baz" a single
+	// IDENTIFIER token instead of identifiers separated by PERIOD tokens. A
+	// '.' is only part of an identifier if another identifier follows it.
+	DottedIdentifiers bool
+
+	// TabWidth, if greater than 1, makes a tab advance the column to the next
+	// tab stop, every TabWidth columns, as an editor would display it.
+	// Otherwise a tab counts as a single column like any other rune.
+	TabWidth int
+
+	// Nul selects how NUL bytes between tokens are handled. Inside string
+	// literals and comments they're always allowed.
+	Nul NulHandling
+
+	// Encoding selects how the input is decoded into runes. Token values are
+	// always UTF-8 strings, converted from the input if necessary, but with
+	// ByteValues Token.Bytes holds the input bytes as they are. Positions are
+	// byte offsets into the input either way.
+	Encoding Encoding
+
+	// TrackRuneOffsets makes the lexer set Token.RuneOffset, for tools that
+	// index the input by rune rather than by byte.
+	TrackRuneOffsets bool
+
+	// MarkTruncated makes the lexer set Token.Truncated, for callers that
+	// lex input in chunks and need to know which tokens might continue in
+	// the next one.
+	MarkTruncated bool
+
+	// InternIdentifiers makes all IDENTIFIER tokens with the same text share
+	// a single Val string, allocated the first time the name is seen. For
+	// lexers reading from an io.Reader, whose values are otherwise allocated
+	// one by one, this saves an allocation for every repeated name. Other
+	// lexers already slice short values from shared chunks of the input (see
+	// Token.Val), so there it mainly stops identifiers that outlive the rest
+	// of the tokens from keeping those chunks alive. It has no effect with
+	// ByteValues.
+	InternIdentifiers bool
+
+	// FloatKeywords makes the identifiers "inf", "Inf", "nan" and "NaN"
+	// NUMBER tokens, for which Token.Float returns an infinity or NaN. Other
+	// spellings accepted by strconv.ParseFloat, such as "Infinity", can be
+	// mapped to NUMBER with Keywords. With SignedNumbers, "inf" and "Inf"
+	// may also be signed, as in "-inf".
+	FloatKeywords bool
+
+	// Indentation makes the lexer track the indentation of each line and
+	// return an INDENT token when it increases and a DEDENT token for every
+	// level it decreases by, for indentation-sensitive grammars. Blank lines
+	// don't affect indentation, and any open levels are closed by DEDENT
+	// tokens at the end of the input. Indentation implies SignificantNewlines.
+	Indentation bool
+
+	// ByteValues makes the lexer set Token.Bytes to a slice of its input
+	// instead of allocating a string for Token.Val. For lexers created by
+	// NewLexerReaderWithOptions the slices alias internal buffers, which
+	// aren't reused.
+	ByteValues bool
+
+	// MaxTokenLen, if positive, is the maximum length in bytes of a token.
+	// A longer token is reported as an ERROR token, after which the lexer
+	// resumes at the next whitespace. For lexers created by
+	// NewLexerReaderWithOptions this also bounds the memory used: the lexer
+	// stops reading a token once it exceeds the limit, so it may resume
+	// inside the token.
+	MaxTokenLen int
+}
+
+// NulHandling is the type of the Nul option.
+type NulHandling int
+
+// Values for NulHandling
+const (
+	// NulError makes a NUL byte between tokens an ERROR token, like any
+	// other unexpected character.
+	NulError NulHandling = iota
+
+	// NulWhitespace makes NUL bytes separate tokens like spaces do.
+	NulWhitespace
+
+	// NulToken returns each NUL byte as a NUL token.
+	NulToken
+)
+
+// Encoding is the type of the Encoding option.
+type Encoding int
+
+// Values for Encoding
+const (
+	// UTF8 decodes the input as UTF-8, reporting invalid byte sequences as
+	// ERROR tokens.
+	UTF8 Encoding = iota
+
+	// Latin1 decodes each byte of the input as the rune with the same value,
+	// as ISO 8859-1 does, so that "\xE4" is 'ä'. Every byte is valid, and rune
+	// offsets are the same as byte offsets.
+	Latin1
+)
+
+// toUTF8 returns b, which is encoded as enc, converted to UTF-8. It returns b
+// itself if no conversion is needed.
+func toUTF8(b []byte, enc Encoding) []byte {
+	if enc != Latin1 {
+		return b
+	}
+	return latin1ToUTF8(b)
+}
+
+func latin1ToUTF8(b []byte) []byte {
+	for i, c := range b {
+		if c >= utf8.RuneSelf {
+			u := append([]byte(nil), b[:i]...)
+			for _, c := range b[i:] {
+				u = utf8.AppendRune(u, rune(c))
+			}
+			return u
+		}
+	}
+	return b
 }
 
 // Lexer
@@ -134,8 +915,21 @@ var opTable = [...]TokenName{
 // Create a new lexer with NewLexer and then call NextToken repeatedly to get
 // tokens from the stream. The lexer will return a token with the name EOF when
 // done.
+//
+// A Lexer must not be used by more than one goroutine at a time. Lexers for
+// different inputs are independent, though, and may run concurrently (see
+// LexParallel), even when they share a keyword table.
 type Lexer struct {
-	buf []byte
+	buf  []byte
+	opts Options
+
+	// For lexers created by NewLexerReader: the reader input is read from, and
+	// the error that ended reading, if any. buf then holds a window of the
+	// input starting at offset base; otherwise rd is nil and base is 0. All of
+	// the positions below are indices into buf.
+	rd      *bufio.Reader
+	readErr error
+	base    int
 
 	// Current rune.
 	r rune
@@ -145,143 +939,1845 @@ type Lexer struct {
 
 	// Position of the next rune in buf.
 	nextpos int
-}
 
-// NewLexer creates a new lexer for the given input.
-func NewLexer(buf []byte) *Lexer {
-	lex := Lexer{buf, -1, 0, 0}
+	// Line and column of the current rune. Both are 1-based; the column
+	// counts runes, not bytes, and tab stops with the TabWidth option.
+	line int
+	col  int
 
-	// Prime the lexer by calling .next
-	lex.next()
-	return &lex
-}
+	// The number of runes before the current line, less any columns skipped
+	// by tabs on it, so that the rune offset of the current rune is
+	// lineRunes+col-1.
+	lineRunes int
 
-func (lex *Lexer) NextToken() Token {
-	// Skip non-tokens like whitespace and check for EOF.
-	lex.skipNontokens()
-	if lex.r < 0 {
-		return Token{EOF, "", lex.nextpos}
-	}
+	// Position, line, column and rune offset of the first rune of the token
+	// being scanned.
+	start      int
+	startLine  int
+	startCol   int
+	startRunes int
 
-	// Is this an operator?
-	if int(lex.r) < len(opTable) {
-		if opName := opTable[lex.r]; opName != ERROR {
-			if opName == DIVIDE {
-				// Special case: '/' may be the start of a comment.
-				if lex.peekNextByte() == '/' {
-					return lex.scanComment()
-				}
-			}
-			startpos := lex.rpos
-			lex.next()
-			return Token{opName, string(lex.buf[startpos:lex.rpos]), startpos}
-		}
-	}
+	// Tokens pushed back by Unread or buffered by PeekToken; the last one is
+	// returned first.
+	pushback []Token
 
-	// Not an operator. Try other types of tokens.
-	if isAlpha(lex.r) {
-		return lex.scanIdentifier()
-	} else if isDigit(lex.r) {
-		return lex.scanNumber()
-	} else if lex.r == '"' {
-		return lex.scanQuote()
-	}
+	// Set once Tokens has returned the final token of the input.
+	drained bool
+
+	// For the Indentation option: the widths of the currently open
+	// indentation levels, the number of DEDENT tokens still to be returned,
+	// and whether the current rune is at the start of a line.
+	indents     []int
+	dedents     int
+	atLineStart bool
+
+	// Set when the token being scanned has exceeded MaxTokenLen while
+	// reading more input. The lexer then acts as if at EOF until
+	// skipLongToken resumes it.
+	tooLong bool
+
+	// For the MarkTruncated option: set when a peek past the current rune
+	// of the token being scanned reached the end of the input.
+	peekedEOF bool
+
+	// For the CollectStats option: the number of tokens returned by name.
+	stats map[TokenName]int
+
+	// The last token scanned other than comments, whitespace and tokens
+	// dropped by the Skip option, or the zero Token at the start of the input.
+	last Token
 
-	return makeErrorToken(lex.rpos)
+	// Identifier values seen so far, with the InternIdentifiers option.
+	interned map[string]string
+
+	// A string copy of buf[chunkStart:chunkStart+len(chunk)] that token
+	// values are sliced from, so that each one doesn't need an allocation of
+	// its own; see text.
+	chunk      string
+	chunkStart int
+
+	// The mode stack of PushMode and PopMode; the last mode is the current one.
+	modes []Mode
 }
 
-// next advances the lexer's internal state to point to the next run in the
-// input.
-func (lex *Lexer) next() {
-	if lex.nextpos < len(lex.buf) {
-		lex.rpos = lex.nextpos
+// Mode selects how the lexer scans sequences whose meaning depends on the
+// context they appear in. A parser switches modes with PushMode and PopMode.
+type Mode int
 
-		// r is the current rune, w is its width. We start by assuming the
-		// common case - that the current rune is ASCII (and thus has width=1).
-		r, w := rune(lex.buf[lex.nextpos]), 1
+// Values for Mode
+const (
+	// ModeDefault is the mode of a lexer with an empty mode stack.
+	ModeDefault Mode = iota
 
-		if r > utf8.RuneSelf {
-			// The current rune is not actually ASCII, so we have to decode it
-			// properly.
-			r, w = utf8.DecodeRune(lex.buf[lex.nextpos:])
-		}
+	// ModeAngleBrackets is for use inside "<...>" brackets, such as the
+	// argument list of a generic type. A '>' is always a single R_ANG token,
+	// so that "list<list<int>>" closes both brackets where ModeDefault would
+	// scan ">>" as SHR.
+	ModeAngleBrackets
+)
 
-		lex.nextpos += w
-		lex.r = r
-	} else {
-		lex.rpos = len(lex.buf)
-		lex.r = -1 // EOF
-	}
+// MaxPushback is the maximum number of tokens that can be pushed back with
+// Unread at any time. A token buffered by PeekToken counts towards this limit.
+const MaxPushback = 3
+
+// ErrPushbackFull is returned by Unread when MaxPushback tokens have already
+// been pushed back.
+var ErrPushbackFull = errors.New("lexer: too many tokens pushed back")
+
+// NewLexer creates a new lexer for the given input. Like all lexers, it skips
+// a UTF-8 byte order mark at the start of the input.
+func NewLexer(buf []byte) *Lexer {
+	return NewLexerWithOptions(buf, Options{})
 }
 
-// peekNextByte returns the next byte in the stream (the one after lex.r).
-// Note: a single byte is peeked at - if there's a rune longer than a byte
-// there, only its first byte is returned.
-func (lex *Lexer) peekNextByte() rune {
-	if lex.nextpos < len(lex.buf) {
-		return rune(lex.buf[lex.nextpos])
-	} else {
-		return -1
-	}
+// NewLexerReader creates a new lexer that reads its input incrementally from r,
+// so the input doesn't have to be held in memory all at once. Token positions
+// are still byte offsets from the start of the stream.
+func NewLexerReader(r io.Reader) *Lexer {
+	return NewLexerReaderWithOptions(r, Options{})
 }
 
-func (lex *Lexer) skipNontokens() {
-	for lex.r == ' ' || lex.r == '\t' || lex.r == '\n' || lex.r == '\r' {
-		lex.next()
+// NewLexerReaderWithOptions creates a new lexer like NewLexerReader, configured
+// by opts.
+func NewLexerReaderWithOptions(r io.Reader, opts Options) *Lexer {
+	if opts.Indentation {
+		opts.SignificantNewlines = true
 	}
+	lex := Lexer{opts: opts}
+	lex.reset(nil, bufio.NewReader(r))
+	return &lex
 }
 
-func (lex *Lexer) scanIdentifier() Token {
-	startpos := lex.rpos
-	for isAlpha(lex.r) || isDigit(lex.r) {
-		lex.next()
-	}
-	return Token{IDENTIFIER, string(lex.buf[startpos:lex.rpos]), startpos}
+// NewLexerWithKeywords creates a new lexer for the given input that returns
+// the token name keywords[id] for any identifier id found in keywords.
+func NewLexerWithKeywords(buf []byte, keywords map[string]TokenName) *Lexer {
+	return NewLexerWithOptions(buf, Options{Keywords: keywords})
 }
 
-func (lex *Lexer) scanNumber() Token {
-	startpos := lex.rpos
-	for isDigit(lex.r) {
-		lex.next()
-	}
-	return Token{NUMBER, string(lex.buf[startpos:lex.rpos]), startpos}
+// NewLexerWithOps creates a new lexer for the given input that also lexes the
+// single-character operators in extra; see Options.Operators.
+func NewLexerWithOps(buf []byte, extra map[rune]TokenName) *Lexer {
+	return NewLexerWithOptions(buf, Options{Operators: extra})
 }
 
-func (lex *Lexer) scanQuote() Token {
-	startpos := lex.rpos
-	lex.next()
-	for lex.r > 0 && lex.r != '"' {
-		lex.next()
+// NewLexerWithOptions creates a new lexer for the given input, configured by
+// opts.
+func NewLexerWithOptions(buf []byte, opts Options) *Lexer {
+	if opts.Indentation {
+		opts.SignificantNewlines = true
 	}
+	lex := Lexer{opts: opts}
+	lex.Reset(buf)
+	return &lex
+}
 
-	if lex.r < 0 {
-		return makeErrorToken(startpos)
-	} else {
-		lex.next()
-		return Token{QUOTE, string(lex.buf[startpos:lex.rpos]), startpos}
-	}
+// Reset reinitializes the lexer to lex buf from the start, keeping its options
+// but discarding all other state, including any peeked or pushed back tokens.
+// This lets a lexer be reused (for example from a sync.Pool) instead of
+// allocating a new one for every input.
+func (lex *Lexer) Reset(buf []byte) {
+	lex.reset(buf, nil)
 }
 
-func (lex *Lexer) scanComment() Token {
-	startpos := lex.rpos
-	lex.next()
-	for lex.r > 0 && lex.r != '\n' {
-		lex.next()
+// reset reinitializes the lexer to lex buf, followed by the input from rd if
+// it isn't nil.
+func (lex *Lexer) reset(buf []byte, rd *bufio.Reader) {
+	*lex = Lexer{
+		buf:         buf,
+		opts:        lex.opts,
+		rd:          rd,
+		r:           -1,
+		line:        1,
+		col:         1,
+		pushback:    lex.pushback[:0],
+		indents:     lex.indents[:0],
+		atLineStart: true,
 	}
 
-	tok := Token{COMMENT, string(lex.buf[startpos:lex.rpos]), startpos}
+	// Prime the lexer by calling .next
 	lex.next()
-	return tok
-}
 
-func isAlpha(r rune) bool {
-	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || r == '_' || r == '$'
+	// A byte order mark isn't part of the content, so skip it. Offsets still
+	// count it, so that they index the input as given, but columns don't.
+	if lex.r == '\uFEFF' {
+		lex.next()
+		lex.col = 1
+		lex.lineRunes = 1
+	}
+	if lex.opts.SkipShebang && lex.lookingAt("#!") {
+		for lex.r >= 0 && lex.r != '\n' {
+			lex.next()
+		}
+	}
+	lex.start = lex.rpos
 }
 
-func isDigit(r rune) bool {
+// NextToken returns the next token from the input. Comments are returned as
+// COMMENT tokens unless the SkipComments option is set, and tokens named in the
+// Skip option are left out. At the end of the input NextToken returns an EOF
+// token positioned at the length of the input, and keeps returning the same
+// EOF token on any further calls.
+func (lex *Lexer) NextToken() Token {
+	if n := len(lex.pushback); n > 0 {
+		tok := lex.pushback[n-1]
+		lex.pushback = lex.pushback[:n-1]
+		return tok
+	}
+
+	for {
+		tok := lex.scanToken()
+		if max := lex.opts.MaxTokenLen; max > 0 && (lex.tooLong || lex.rpos-lex.start > max) {
+			tok = lex.skipLongToken()
+		}
+		if tok.Name == COMMENT && lex.opts.SkipComments {
+			continue
+		}
+		if lex.opts.Skip[tok.Name] && tok.Name != EOF && tok.Name != ERROR {
+			continue
+		}
+		if tok.Name != COMMENT && tok.Name != WHITESPACE {
+			lex.last = tok
+		}
+		if lex.opts.CollectStats {
+			if lex.stats == nil {
+				lex.stats = make(map[TokenName]int)
+			}
+			lex.stats[tok.Name]++
+		}
+		return tok
+	}
+}
+
+// Clone returns an independent copy of the lexer at its current position,
+// including any peeked or pushed back tokens, so that a parser can backtrack by
+// continuing with the copy. The copy shares the (read-only) input buffer with
+// the original. Cloning a lexer created by NewLexerReader panics, because both
+// copies would have to read from the same reader.
+func (lex *Lexer) Clone() *Lexer {
+	if lex.rd != nil {
+		panic("lexer: Clone of a lexer reading from an io.Reader")
+	}
+	c := *lex
+	c.pushback = append([]Token(nil), lex.pushback...)
+	c.indents = append([]int(nil), lex.indents...)
+	c.modes = append([]Mode(nil), lex.modes...)
+	c.stats = lex.Stats()
+	// Interned strings are immutable, but the map isn't safe to share.
+	c.interned = nil
+	return &c
+}
+
+// Stats returns the number of tokens of each name returned by NextToken so
+// far, with the CollectStats option. Tokens returned again after PeekToken or
+// Unread are only counted once. Without the option, Stats returns nil.
+func (lex *Lexer) Stats() map[TokenName]int {
+	if lex.stats == nil {
+		return nil
+	}
+	stats := make(map[TokenName]int, len(lex.stats))
+	for name, n := range lex.stats {
+		stats[name] = n
+	}
+	return stats
+}
+
+// Tokens drains the lexer and returns all remaining tokens, up to and including
+// the final EOF token or the first ERROR token. The lexer is single-use in this
+// respect: once Tokens has returned, subsequent calls return an empty slice.
+func (lex *Lexer) Tokens() []Token {
+	toks := []Token{}
+	if lex.drained {
+		return toks
+	}
+	for {
+		tok := lex.NextToken()
+		toks = append(toks, tok)
+		if tok.Name == EOF || tok.Name == ERROR {
+			lex.drained = true
+			return toks
+		}
+	}
+}
+
+// NextN returns the next n tokens, or fewer if it reaches the final EOF token or
+// an ERROR token first, which is then the last token returned. Like Tokens, it
+// returns an empty slice once either has been returned, so a caller can page
+// through the input until it gets fewer than n tokens.
+func (lex *Lexer) NextN(n int) []Token {
+	toks := []Token{}
+	for len(toks) < n && !lex.drained {
+		tok := lex.NextToken()
+		toks = append(toks, tok)
+		if tok.Name == EOF || tok.Name == ERROR {
+			lex.drained = true
+		}
+	}
+	return toks
+}
+
+// Lex returns all the tokens in buf, as returned by Tokens on a new lexer.
+func Lex(buf []byte) []Token {
+	return NewLexer(buf).Tokens()
+}
+
+// LexParallel lexes each of bufs like Lex, spreading the work over up to
+// GOMAXPROCS goroutines, and returns the tokens of each in the same order as
+// bufs.
+func LexParallel(bufs [][]byte) [][]Token {
+	out := make([][]Token, len(bufs))
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(bufs) {
+		workers = len(bufs)
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				out[i] = Lex(bufs[i])
+			}
+		}()
+	}
+	for i := range bufs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return out
+}
+
+// LexError is an error found while lexing, as reported by an ERROR token.
+type LexError struct {
+	Pos Position
+	Msg string
+}
+
+// Error returns the message prefixed with the position, as in "2:5: empty
+// character literal".
+func (e LexError) Error() string {
+	return e.Pos.String() + ": " + e.Msg
+}
+
+// ErrorList is a list of lexing errors, as returned by LexCollect.
+type ErrorList []LexError
+
+// Error describes the first error in the list and how many more there are.
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// Sort sorts the list by position. Errors at the same position keep their
+// order.
+func (l ErrorList) Sort() {
+	sort.SliceStable(l, func(i, j int) bool { return l[i].Pos.Offset < l[j].Pos.Offset })
+}
+
+// Err returns l as an error, or nil if l is empty.
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// LexCollect lexes all of buf, like Lex, but doesn't stop at errors: after
+// each ERROR token it skips to the next whitespace and carries on. It returns
+// the other tokens, up to and including EOF, and an error for each ERROR
+// token, in the order they were found.
+func LexCollect(buf []byte) ([]Token, ErrorList) {
+	lex := NewLexer(buf)
+	var toks []Token
+	var errs ErrorList
+	for {
+		tok := lex.NextToken()
+		switch tok.Name {
+		case ERROR:
+			errs = append(errs, LexError{tok.Pos, tok.Msg})
+			lex.skipToWhitespace()
+			continue
+		case EOF:
+			return append(toks, tok), errs
+		}
+		toks = append(toks, tok)
+	}
+}
+
+// closers maps each opening bracket token to the matching closing one.
+var closers = map[TokenName]TokenName{
+	L_PAREN:   R_PAREN,
+	L_BRACE:   R_BRACE,
+	L_BRACKET: R_BRACKET,
+	L_ANG:     R_ANG,
+}
+
+// CheckBalanced checks that the parentheses, braces and square brackets in
+// tokens are balanced and properly nested. Otherwise it returns an error
+// giving the position of the first problem.
+func CheckBalanced(tokens []Token) error {
+	return checkBalanced(tokens, false)
+}
+
+// CheckBalancedAngles is like CheckBalanced, but also treats L_ANG and R_ANG
+// as brackets, as for TableGen's "list<int>".
+func CheckBalancedAngles(tokens []Token) error {
+	return checkBalanced(tokens, true)
+}
+
+func checkBalanced(tokens []Token, angles bool) error {
+	var open []Token
+	for _, tok := range tokens {
+		if (tok.Name == L_ANG || tok.Name == R_ANG) && !angles {
+			continue
+		}
+		switch tok.Name {
+		case L_PAREN, L_BRACE, L_BRACKET, L_ANG:
+			open = append(open, tok)
+		case R_PAREN, R_BRACE, R_BRACKET, R_ANG:
+			if len(open) == 0 {
+				return fmt.Errorf("%s: unmatched %s", tok.Pos, tok.ValString())
+			}
+			opener := open[len(open)-1]
+			if closers[opener.Name] != tok.Name {
+				return fmt.Errorf("%s: %s doesn't match %s at %s", tok.Pos, tok.ValString(), opener.ValString(), opener.Pos)
+			}
+			open = open[:len(open)-1]
+		}
+	}
+	if len(open) > 0 {
+		opener := open[len(open)-1]
+		return fmt.Errorf("%s: unclosed %s", opener.Pos, opener.ValString())
+	}
+	return nil
+}
+
+// SplitTokens is a bufio.SplitFunc that splits the input into the tokens
+// returned by NextToken on a lexer with default options, dropping the
+// whitespace between them. An ERROR token stops the scan with an error whose
+// text is the token's message.
+func SplitTokens(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	var lex *Lexer
+	if atEOF {
+		lex = NewLexerWithOptions(data, Options{ByteValues: true})
+	} else {
+		// Read data like a stream, so we can tell whether the lexer needed to
+		// look past its end: the token may continue in input that the
+		// scanner hasn't read yet.
+		lex = &Lexer{opts: Options{ByteValues: true}}
+		lex.reset(nil, bufio.NewReader(bytes.NewReader(data)))
+	}
+	tok := lex.NextToken()
+	if !atEOF && lex.readErr != nil {
+		return 0, nil, nil
+	}
+	switch tok.Name {
+	case EOF:
+		return len(data), nil, nil
+	case ERROR:
+		return 0, nil, errors.New(tok.Msg)
+	}
+	return tok.Pos.Offset + len(tok.Bytes), tok.Bytes, nil
+}
+
+// Stream lexes the input in a new goroutine and sends the tokens on the
+// returned channel, up to and including the final EOF token or the first ERROR
+// token. The channel is closed after the last token has been sent, or early if
+// ctx is cancelled. The lexer must not be used by the caller while the stream
+// is running.
+func (lex *Lexer) Stream(ctx context.Context) <-chan Token {
+	out := make(chan Token)
+	go func() {
+		defer close(out)
+		for ctx.Err() == nil {
+			tok := lex.NextToken()
+			select {
+			case out <- tok:
+			case <-ctx.Done():
+				return
+			}
+			if tok.Name == EOF || tok.Name == ERROR {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// PeekToken returns the next token without consuming it: the following call to
+// NextToken returns the same token. Repeated calls to PeekToken return the same
+// token until NextToken is called.
+func (lex *Lexer) PeekToken() Token {
+	if n := len(lex.pushback); n > 0 {
+		return lex.pushback[n-1]
+	}
+	tok := lex.NextToken()
+	lex.pushback = append(lex.pushback, tok)
+	return tok
+}
+
+// AcceptIf consumes and returns the next token if its name is name. Otherwise
+// it leaves the token to be returned by the next call to NextToken, and returns
+// false.
+func (lex *Lexer) AcceptIf(name TokenName) (Token, bool) {
+	if tok := lex.PeekToken(); tok.Name == name {
+		return lex.NextToken(), true
+	}
+	return Token{}, false
+}
+
+// Unread pushes tok back so that the next call to NextToken returns it again.
+// Tokens are returned in the reverse order of being pushed back, so reading two
+// tokens and unreading them in reverse order rewinds past both. Up to
+// MaxPushback tokens may be pushed back at once; beyond that Unread returns
+// ErrPushbackFull and leaves the lexer unchanged.
+func (lex *Lexer) Unread(tok Token) error {
+	if len(lex.pushback) >= MaxPushback {
+		return ErrPushbackFull
+	}
+	lex.pushback = append(lex.pushback, tok)
+	return nil
+}
+
+// PushMode makes m the lexer's current mode until it's removed by PopMode.
+// Modes only affect tokens scanned afterwards, not any already peeked or
+// pushed back.
+func (lex *Lexer) PushMode(m Mode) {
+	lex.modes = append(lex.modes, m)
+}
+
+// PopMode removes the current mode, returning to the one before it, and
+// returns the removed mode. It panics if no mode has been pushed.
+func (lex *Lexer) PopMode() Mode {
+	n := len(lex.modes)
+	if n == 0 {
+		panic("lexer: PopMode with an empty mode stack")
+	}
+	m := lex.modes[n-1]
+	lex.modes = lex.modes[:n-1]
+	return m
+}
+
+// Mode returns the lexer's current mode: the last one pushed, or ModeDefault.
+func (lex *Lexer) Mode() Mode {
+	if n := len(lex.modes); n > 0 {
+		return lex.modes[n-1]
+	}
+	return ModeDefault
+}
+
+// AtEOF reports whether the lexer has scanned all of its input, so that any
+// token scanned from now on is EOF (or, with the Indentation option, DEDENT).
+// Tokens already peeked or pushed back may still be returned first.
+func (lex *Lexer) AtEOF() bool {
+	return lex.r < 0 && !lex.tooLong
+}
+
+// ReadGroup reads a bracketed group of tokens, such as a parenthesized
+// argument list: the next token must be open, and ReadGroup consumes tokens up
+// to and including the matching close, returning the tokens between them.
+// Nested open and close pairs are part of the group. If the next token isn't
+// open, ReadGroup returns an error and leaves the token to be read again. An
+// ERROR token or the end of the input before the group is closed is an error
+// too; ReadGroup then returns the tokens read so far.
+func (lex *Lexer) ReadGroup(open, close TokenName) ([]Token, error) {
+	first, ok := lex.AcceptIf(open)
+	if !ok {
+		tok := lex.PeekToken()
+		return nil, fmt.Errorf("%s: expected %s, found %s", tok.Pos, open, tok.Name)
+	}
+	var toks []Token
+	for depth := 1; ; {
+		tok := lex.NextToken()
+		switch tok.Name {
+		case close:
+			if depth--; depth == 0 {
+				return toks, nil
+			}
+		case open:
+			depth++
+		case ERROR:
+			return toks, LexError{tok.Pos, tok.Msg}
+		case EOF:
+			return toks, fmt.Errorf("%s: unclosed %s", first.Pos, first.ValString())
+		}
+		toks = append(toks, tok)
+	}
+}
+
+// Rune returns the current rune: the first rune the next token will be scanned
+// from, or -1 at the end of the input.
+func (lex *Lexer) Rune() rune {
+	return lex.r
+}
+
+// LastToken returns the last token scanned other than comments, whitespace and
+// tokens left out by the Skip option, which is what the SignedNumbers and
+// AutoSemi options look at to decide how to lex what follows. Tokens scanned
+// ahead by PeekToken count too. At the start of the input LastToken returns the
+// zero Token, whose name is NONE.
+func (lex *Lexer) LastToken() Token {
+	return lex.last
+}
+
+// Offset returns the byte offset of the current rune in the input.
+func (lex *Lexer) Offset() int {
+	return lex.base + lex.rpos
+}
+
+// Progress returns how many bytes of the input the lexer has scanned so far
+// and the total size of the input, for progress reporting. At EOF consumed is
+// total. For a lexer created by NewLexerReader the total isn't known, and is
+// reported as -1.
+func (lex *Lexer) Progress() (consumed, total int) {
+	if lex.rd != nil {
+		return lex.Offset(), -1
+	}
+	return lex.Offset(), len(lex.buf)
+}
+
+// Advance moves the lexer on to the next rune, for scanning input that the
+// lexer's own rules don't handle. NextToken continues from the current rune,
+// so Advance should leave it at a token boundary. Tokens already peeked or
+// pushed back aren't affected: NextToken still returns them first.
+func (lex *Lexer) Advance() {
+	lex.next()
+}
+
+// LineText returns the text of the input line containing the byte offset pos,
+// without its line terminator, and the 1-based column of pos within it as the
+// lexer would report it, for printing diagnostics such as a caret under an
+// ERROR token. For a lexer created by NewLexerReader only input from the start
+// of the current token on is still available; for an offset outside the
+// available input LineText returns "" and 0.
+func (lex *Lexer) LineText(pos int) (line string, col int) {
+	i := pos - lex.base
+	if i < 0 || i > len(lex.buf) {
+		return "", 0
+	}
+	start, end := i, i
+	for start > 0 && !isLineBreak(lex.buf, start-1) {
+		start--
+	}
+	for end < len(lex.buf) && lex.buf[end] != '\n' && lex.buf[end] != '\r' {
+		end++
+	}
+	return string(toUTF8(lex.buf[start:end], lex.opts.Encoding)), column(toUTF8(lex.buf[start:i], lex.opts.Encoding), lex.opts.TabWidth)
+}
+
+// Context returns the input around the byte offset pos, up to radius runes
+// before it and radius runes from it on, for showing where an error occurred.
+// The text is cut at the ends of the available input (see LineText) and at
+// rune boundaries: an offset within a multibyte rune counts as the start of
+// that rune. Line breaks aren't treated specially.
+func (lex *Lexer) Context(pos, radius int) string {
+	if radius <= 0 {
+		return ""
+	}
+	i := pos - lex.base
+	if i < 0 {
+		i = 0
+	} else if i > len(lex.buf) {
+		i = len(lex.buf)
+	}
+	if lex.opts.Encoding == Latin1 {
+		start, end := max(i-radius, 0), min(i+radius, len(lex.buf))
+		return string(toUTF8(lex.buf[start:end], Latin1))
+	}
+	for i > 0 && i < len(lex.buf) && !utf8.RuneStart(lex.buf[i]) {
+		i--
+	}
+	start, end := i, i
+	for n := 0; n < radius && start > 0; n++ {
+		_, size := utf8.DecodeLastRune(lex.buf[:start])
+		start -= size
+	}
+	for n := 0; n < radius && end < len(lex.buf); n++ {
+		_, size := utf8.DecodeRune(lex.buf[end:])
+		end += size
+	}
+	return string(lex.buf[start:end])
+}
+
+// isLineBreak reports whether buf[i] ends a line: it's a '\n', or a '\r' that
+// isn't followed by one.
+func isLineBreak(buf []byte, i int) bool {
+	return buf[i] == '\n' || buf[i] == '\r' && (i+1 == len(buf) || buf[i+1] != '\n')
+}
+
+// skipLongToken returns an ERROR token for a token longer than MaxTokenLen,
+// and skips to the next whitespace.
+func (lex *Lexer) skipLongToken() Token {
+	tok := lex.makeErrorToken("token too long")
+	lex.tooLong = false
+	lex.start = lex.rpos
+	if lex.r < 0 {
+		// Either the real EOF, or the end of the part of the token read.
+		lex.next()
+	}
+	lex.skipToWhitespace()
+	return tok
+}
+
+// skipToWhitespace skips input up to the next whitespace rune or EOF, to
+// resynchronize after an error.
+func (lex *Lexer) skipToWhitespace() {
+	for lex.r >= 0 && lex.r != ' ' && lex.r != '\t' && lex.r != '\n' && lex.r != '\r' {
+		lex.next()
+		// Drop the skipped input so fill doesn't have to keep it.
+		lex.start = lex.rpos
+	}
+}
+
+// defaultSignedNumberAfter is the default for Options.SignedNumberAfter.
+var defaultSignedNumberAfter = []TokenName{L_PAREN, L_BRACKET, L_BRACE, COMMA, COLON, SEMI, EQUALS, NEWLINE, INDENT}
+
+// signAllowed reports whether a sign may start a number at this point, for the
+// SignedNumbers option.
+func (lex *Lexer) signAllowed() bool {
+	if lex.last.Name == NONE {
+		return true
+	}
+	after := lex.opts.SignedNumberAfter
+	if after == nil {
+		after = defaultSignedNumberAfter
+	}
+	for _, name := range after {
+		if lex.last.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultAutoSemiAfter is the default for Options.AutoSemiAfter.
+var defaultAutoSemiAfter = []TokenName{IDENTIFIER, NUMBER, QUOTE, RAW_QUOTE, BLOCK_QUOTE, CHAR, R_PAREN, R_BRACE, R_BRACKET}
+
+// scanAutoSemi implements the AutoSemi option. It returns the SEMI token to
+// insert, or WHITESPACE up to the end of the line with EmitWhitespace, when
+// the last token could end a statement and nothing but whitespace follows it
+// on its line. It returns false otherwise.
+func (lex *Lexer) scanAutoSemi() (Token, bool) {
+	after := lex.opts.AutoSemiAfter
+	if after == nil {
+		after = defaultAutoSemiAfter
+	}
+	found := false
+	for _, name := range after {
+		if lex.last.Name == name {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return Token{}, false
+	}
+
+	// Line breaks mustn't be skipped here, even if they aren't significant.
+	blank := func(r rune) bool { return r != '\n' && r != '\r' && lex.isNontoken(r) }
+	if lex.opts.EmitWhitespace && blank(lex.r) {
+		lex.startToken()
+		for blank(lex.r) {
+			lex.next()
+		}
+		return lex.emit(WHITESPACE), true
+	}
+	for blank(lex.r) {
+		lex.next()
+		lex.start = lex.rpos
+	}
+	if lex.r != '\n' && lex.r != '\r' && !lex.AtEOF() {
+		return Token{}, false
+	}
+	lex.startToken()
+	return lex.emit(SEMI), true
+}
+
+// scanToken scans the next token, including comments, from the input.
+func (lex *Lexer) scanToken() Token {
+	if lex.opts.Indentation {
+		if tok, ok := lex.scanIndentation(); ok {
+			return tok
+		}
+	}
+
+	if lex.opts.AutoSemi {
+		if tok, ok := lex.scanAutoSemi(); ok {
+			return tok
+		}
+	}
+
+	// Skip non-tokens like whitespace and check for EOF.
+	if lex.opts.EmitWhitespace && lex.isNontoken(lex.r) {
+		lex.startToken()
+		for lex.isNontoken(lex.r) {
+			lex.next()
+		}
+		return lex.emit(WHITESPACE)
+	}
+	lex.skipNontokens()
+	lex.startToken()
+	if lex.r < 0 {
+		if lex.readErr != nil && lex.readErr != io.EOF {
+			return lex.makeErrorToken(lex.readErr.Error())
+		}
+		if n := len(lex.indents); n > 0 {
+			lex.indents = lex.indents[:n-1]
+			return lex.emit(DEDENT)
+		}
+		return lex.emit(EOF)
+	}
+
+	if lex.opts.LineCommentPrefixes != nil {
+		for _, prefix := range lex.opts.LineCommentPrefixes {
+			if prefix != "" && lex.lookingAt(prefix) {
+				return lex.scanComment()
+			}
+		}
+	}
+
+	if lex.opts.IsIdentStart != nil && lex.opts.IsIdentStart(lex.r) {
+		return lex.scanIdentifier()
+	}
+
+	if lex.r == 0 && lex.opts.Nul == NulToken {
+		lex.next()
+		return lex.emit(NUL)
+	}
+
+	if name, ok := lex.opts.DoubledOperators[lex.r]; ok && lex.peekRune() == lex.r && !(lex.r == '>' && lex.Mode() == ModeAngleBrackets) {
+		lex.next()
+		lex.next()
+		return lex.emit(name)
+	}
+
+	// Is this an operator?
+	if opName, ok := lex.opts.Operators[lex.r]; ok {
+		if opName != NONE {
+			lex.next()
+			return lex.emit(opName)
+		}
+	} else if int(lex.r) < len(opTable) {
+		if opName := opTable[lex.r]; opName != NONE {
+			if opName == PERIOD && isDigit(lex.peekNextByte()) {
+				// Special case: '.' followed by a digit starts a number like ".5".
+				return lex.scanNumber()
+			}
+			if (opName == PLUS || opName == MINUS) && lex.opts.SignedNumbers && lex.signAllowed() {
+				if next := lex.peekNextByte(); isDigit(next) || next == '.' && isDigit(lex.peekByte(1)) {
+					lex.next()
+					return lex.scanNumber()
+				}
+				if lex.opts.FloatKeywords && lex.infAfterSign() {
+					for range len("+inf") {
+						lex.next()
+					}
+					return lex.emit(NUMBER)
+				}
+			}
+			if opName == DIVIDE {
+				// Special case: '/' may be the start of a comment.
+				switch lex.peekNextByte() {
+				case '/':
+					if lex.opts.LineCommentPrefixes == nil {
+						return lex.scanComment()
+					}
+				case '*':
+					return lex.scanBlockComment()
+				}
+			}
+
+			// Maximal munch: prefer a two-character operator when the next
+			// character completes one.
+			if int(lex.r) < len(compoundOpTable) && !(opName == R_ANG && lex.Mode() == ModeAngleBrackets) {
+				for _, op := range compoundOpTable[lex.r] {
+					if lex.peekNextByte() == op.second {
+						lex.next()
+						opName = op.name
+						break
+					}
+				}
+			}
+			lex.next()
+			return lex.emit(opName)
+		}
+	}
+
+	// Not an operator. Try other types of tokens.
+	if lex.opts.IsIdentStart == nil && isIdentStart(lex.r) {
+		return lex.scanIdentifier()
+	} else if isDigit(lex.r) {
+		return lex.scanNumber()
+	} else if lex.r == '"' {
+		if lex.lookingAt(`"""`) {
+			return lex.scanBlockQuote()
+		}
+		return lex.scanQuote()
+	} else if lex.r == '`' {
+		return lex.scanRawQuote()
+	} else if lex.r == '\'' && !lex.opts.DisableCharLiterals {
+		return lex.scanChar()
+	} else if lex.r == '\n' || lex.r == '\r' {
+		// Only reached with SignificantNewlines; otherwise line breaks are
+		// skipped above.
+		return lex.scanNewline()
+	}
+
+	// utf8.DecodeRune reports an invalid byte as a RuneError of width 1 (a
+	// properly encoded U+FFFD is 3 bytes wide). Skip the bad byte so lexing
+	// can carry on after the error.
+	if lex.r == utf8.RuneError && lex.nextpos-lex.rpos == 1 {
+		lex.next()
+		return lex.makeErrorToken("invalid UTF-8")
+	}
+	return lex.makeErrorToken(fmt.Sprintf("unexpected character %q", lex.r))
+}
+
+// startToken records the current rune as the first rune of a new token.
+func (lex *Lexer) startToken() {
+	lex.start = lex.rpos
+	lex.peekedEOF = false
+	lex.startLine = lex.line
+	lex.startCol = lex.col
+	lex.startRunes = lex.lineRunes + lex.col - 1
+}
+
+// startPos returns the position recorded by startToken.
+func (lex *Lexer) startPos() Position {
+	return Position{lex.base + lex.start, lex.startLine, lex.startCol}
+}
+
+// emit returns a token with the given name spanning from the start recorded by
+// startToken up to (but not including) the current rune.
+func (lex *Lexer) emit(name TokenName) Token {
+	tok := Token{Name: name, Pos: lex.startPos()}
+	if lex.opts.TrackRuneOffsets {
+		tok.RuneOffset = lex.startRunes
+	}
+	if lex.opts.ByteValues {
+		// Limit the capacity so appending to Bytes can't overwrite the input.
+		tok.Bytes = lex.buf[lex.start:lex.rpos:lex.rpos]
+	} else if name == IDENTIFIER && lex.opts.InternIdentifiers {
+		tok.Val = lex.intern(lex.buf[lex.start:lex.rpos])
+	} else {
+		tok.Val = lex.text(lex.start, lex.rpos)
+	}
+	if lex.opts.MarkTruncated {
+		tok.Truncated = lex.truncated(name)
+	}
+	return tok
+}
+
+// valChunkSize is the size of the pieces of the input that text copies into
+// strings at a time.
+const valChunkSize = 4096
+
+// text returns buf[start:end] as a string. Rather than allocating a string for
+// every token, it slices it from a copy of up to valChunkSize bytes of the
+// input, which is shared by the values of all tokens in that part of the input.
+// A token value thus keeps at most that much of the input in memory. Lexers
+// created by NewLexerReader, whose buf changes as they read, and long tokens
+// still get a string of their own. The chunk may extend past the current
+// position, which relies on buf not being modified while it's lexed.
+func (lex *Lexer) text(start, end int) string {
+	if lex.rd != nil || lex.opts.Encoding != UTF8 || end-start > valChunkSize/4 {
+		return string(toUTF8(lex.buf[start:end], lex.opts.Encoding))
+	}
+	if start < lex.chunkStart || end > lex.chunkStart+len(lex.chunk) {
+		lex.chunkStart = start
+		lex.chunk = string(lex.buf[start:min(start+valChunkSize, len(lex.buf))])
+	}
+	return lex.chunk[start-lex.chunkStart : end-lex.chunkStart]
+}
+
+// truncated reports whether a token with the given name that has just been
+// scanned might have continued with more input: see Token.Truncated.
+func (lex *Lexer) truncated(name TokenName) bool {
+	// A token that stops short of the end can still be cut off if the scan
+	// looked ahead to the end, as "1" in "1e" could become "1e5".
+	if !lex.AtEOF() && !lex.peekedEOF {
+		return false
+	}
+	switch name {
+	case EOF, NEWLINE, INDENT, DEDENT, RAW_QUOTE, BLOCK_QUOTE, CHAR:
+		// These end with a delimiter, or are complete without one.
+		return false
+	case QUOTE:
+		// Only "" could still be the start of a block string.
+		return lex.rpos-lex.start == 2
+	case COMMENT:
+		// A line comment runs to the end of the line, but a block comment
+		// is closed.
+		return !bytes.HasPrefix(lex.buf[lex.start:], []byte("/*"))
+	}
+	if r, size := lex.decodeRune(lex.buf[lex.start:]); lex.rpos-lex.start == size {
+		if _, ok := lex.opts.DoubledOperators[r]; ok {
+			return true
+		}
+		if _, ok := lex.opts.Operators[r]; ok {
+			// Operators added by the caller are never longer.
+			return false
+		}
+	}
+	if name.Category() == CatOperator {
+		// Only a single character that can start a longer operator, a
+		// comment or (for '.') a number might be incomplete.
+		if lex.rpos-lex.start != 1 {
+			return false
+		}
+		c := lex.buf[lex.start]
+		return int(c) < len(compoundOpTable) && compoundOpTable[c] != nil || c == '/' || c == '.'
+	}
+	return true
+}
+
+// intern returns the string for b, allocating it only the first time it's seen.
+func (lex *Lexer) intern(b []byte) string {
+	// The compiler doesn't allocate for a string(b) map index.
+	if s, ok := lex.interned[string(b)]; ok {
+		return s
+	}
+	if lex.interned == nil {
+		lex.interned = make(map[string]string)
+	}
+	s := string(toUTF8(b, lex.opts.Encoding))
+	lex.interned[string(b)] = s
+	return s
+}
+
+// makeErrorToken returns an ERROR token with the given message, positioned at
+// the start of the token being scanned.
+func (lex *Lexer) makeErrorToken(msg string) Token {
+	tok := Token{Name: ERROR, Pos: lex.startPos(), Msg: msg}
+	if lex.opts.TrackRuneOffsets {
+		tok.RuneOffset = lex.startRunes
+	}
+	if lex.opts.MarkTruncated {
+		tok.Truncated = lex.AtEOF()
+	}
+	return tok
+}
+
+// makeErrorTokenAtRune returns an ERROR token with the given message,
+// positioned at the current rune.
+func (lex *Lexer) makeErrorTokenAtRune(msg string) Token {
+	tok := Token{Name: ERROR, Pos: Position{lex.base + lex.rpos, lex.line, lex.col}, Msg: msg}
+	if lex.opts.TrackRuneOffsets {
+		tok.RuneOffset = lex.lineRunes + lex.col - 1
+	}
+	return tok
+}
+
+// next advances the lexer's internal state to point to the next run in the
+// input.
+func (lex *Lexer) next() {
+	// Fast path for the common case: moving from one ASCII rune on a line to
+	// another ASCII rune (which thus has width=1) that's already in buf. This is
+	// kept small enough for next to be inlined.
+	if r, pos := lex.r, lex.nextpos; r > '\r' && pos < len(lex.buf) {
+		if b := lex.buf[pos]; b < utf8.RuneSelf {
+			lex.col++
+			lex.rpos = pos
+			lex.r = rune(b)
+			lex.nextpos = pos + 1
+			return
+		}
+	}
+	lex.nextSlow()
+}
+
+// nextSlow is the general case of next, which handles line breaks, multibyte
+// runes, EOF and reading more input.
+func (lex *Lexer) nextSlow() {
+	prev := lex.r
+	defer lex.countRune(prev)
+
+	if lex.rd != nil && lex.nextpos+utf8.UTFMax > len(lex.buf) {
+		if max := lex.opts.MaxTokenLen; max > 0 && lex.nextpos-lex.start > max {
+			// Don't read any more of an overlong token; see skipLongToken.
+			lex.tooLong = true
+			lex.rpos = lex.nextpos
+			lex.r = -1
+			return
+		}
+		lex.fill(utf8.UTFMax)
+	}
+	if lex.nextpos < len(lex.buf) {
+		lex.rpos = lex.nextpos
+
+		// r is the current rune, w is its width. We start by assuming that
+		// the current rune is ASCII (and thus has width=1).
+		r, w := rune(lex.buf[lex.nextpos]), 1
+
+		if r >= utf8.RuneSelf && lex.opts.Encoding == UTF8 {
+			// The current rune is not actually ASCII, so we have to decode it
+			// properly.
+			r, w = utf8.DecodeRune(lex.buf[lex.nextpos:])
+		}
+
+		lex.nextpos += w
+		lex.r = r
+	} else {
+		lex.rpos = len(lex.buf)
+		lex.r = -1 // EOF
+	}
+}
+
+// countRune updates the line and column for moving past prev onto the current
+// rune. Both '\n' and a lone '\r' (as in old Mac files) start a new line; for
+// "\r\n" only the '\n' does, so the pair counts as a single line break.
+func (lex *Lexer) countRune(prev rune) {
+	switch {
+	case prev == '\n' || prev == '\r' && lex.r != '\n':
+		lex.line++
+		lex.lineRunes += lex.col
+		lex.col = 1
+	case prev == '\t' && lex.opts.TabWidth > 1:
+		next := nextTabStop(lex.col, lex.opts.TabWidth)
+		lex.lineRunes -= next - lex.col - 1
+		lex.col = next
+	case prev >= 0:
+		lex.col++
+	}
+}
+
+// nextTabStop returns the column a tab at col advances to, with tab stops
+// every tabWidth columns.
+func nextTabStop(col, tabWidth int) int {
+	return (col-1)/tabWidth*tabWidth + tabWidth + 1
+}
+
+// column returns the column following the text of a line up to it, counting
+// tabs as for the TabWidth option.
+func column(text []byte, tabWidth int) int {
+	if tabWidth <= 1 {
+		return utf8.RuneCount(text) + 1
+	}
+	col := 1
+	for _, r := range string(text) {
+		if r == '\t' {
+			col = nextTabStop(col, tabWidth)
+		} else {
+			col++
+		}
+	}
+	return col
+}
+
+// readChunkSize is the minimum number of bytes fill asks the reader for.
+const readChunkSize = 4096
+
+// fill reads input from the lexer's reader until buf holds at least n bytes
+// from nextpos on, or the reader is exhausted. To keep memory bounded, input
+// before the start of the current token is dropped from buf first. The kept
+// bytes are copied to a new buffer rather than moved so that slices of the old
+// buffer stay valid.
+func (lex *Lexer) fill(n int) {
+	if lex.readErr != nil {
+		return
+	}
+
+	keep := lex.buf[lex.start:]
+	buf := make([]byte, len(keep), len(keep)+n+readChunkSize)
+	copy(buf, keep)
+	lex.base += lex.start
+	lex.rpos -= lex.start
+	lex.nextpos -= lex.start
+	lex.start = 0
+
+	for lex.nextpos+n > len(buf) {
+		m, err := lex.rd.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+m]
+		if err != nil {
+			lex.readErr = err
+			break
+		}
+	}
+	lex.buf = buf
+}
+
+// peekNextByte returns the next byte in the stream (the one after lex.r).
+// Note: a single byte is peeked at - if there's a rune longer than a byte
+// there, only its first byte is returned.
+func (lex *Lexer) peekNextByte() rune {
+	return lex.peekByte(0)
+}
+
+// identStart reports whether r can begin an identifier, using the IsIdentStart
+// option if it's set.
+func (lex *Lexer) identStart(r rune) bool {
+	if f := lex.opts.IsIdentStart; f != nil {
+		return f(r)
+	}
+	return isIdentStart(r)
+}
+
+// peekRune returns the rune after the current one, or -1 at the end of the
+// input.
+func (lex *Lexer) peekRune() rune {
+	if lex.rd != nil && lex.nextpos+utf8.UTFMax > len(lex.buf) {
+		lex.fill(utf8.UTFMax)
+	}
+	if lex.nextpos >= len(lex.buf) {
+		lex.peekedEOF = true
+		return -1
+	}
+	r, _ := lex.decodeRune(lex.buf[lex.nextpos:])
+	return r
+}
+
+// decodeRune is like utf8.DecodeRune, but decodes b as the Encoding option
+// says.
+func (lex *Lexer) decodeRune(b []byte) (r rune, size int) {
+	if lex.opts.Encoding == Latin1 && len(b) > 0 {
+		return rune(b[0]), 1
+	}
+	return utf8.DecodeRune(b)
+}
+
+// lookingAt reports whether the input at the current rune starts with s.
+func (lex *Lexer) lookingAt(s string) bool {
+	if lex.rd != nil && lex.rpos+len(s) > len(lex.buf) {
+		lex.fill(lex.rpos + len(s) - lex.nextpos)
+	}
+	return lex.rpos+len(s) <= len(lex.buf) && string(lex.buf[lex.rpos:lex.rpos+len(s)]) == s
+}
+
+// peekByte returns the byte n bytes past the next one in the stream, so that
+// peekByte(0) is the same as peekNextByte(). It returns -1 past the end of the
+// stream.
+func (lex *Lexer) peekByte(n int) rune {
+	if lex.rd != nil && lex.nextpos+n >= len(lex.buf) {
+		lex.fill(n + 1)
+	}
+	if lex.nextpos+n < len(lex.buf) {
+		return rune(lex.buf[lex.nextpos+n])
+	} else {
+		lex.peekedEOF = true
+		return -1
+	}
+}
+
+// infAfterSign reports whether the current '+' or '-' is directly followed by
+// the float keyword "inf" or "Inf". strconv.ParseFloat rejects a signed NaN,
+// so "nan" and "NaN" are left alone.
+func (lex *Lexer) infAfterSign() bool {
+	if c := lex.peekByte(0); c != 'i' && c != 'I' || lex.peekByte(1) != 'n' || lex.peekByte(2) != 'f' {
+		return false
+	}
+	// A non-ASCII rune after the keyword may continue the identifier, so
+	// don't look any closer.
+	c := lex.peekByte(3)
+	if c >= utf8.RuneSelf {
+		return false
+	}
+	if cont := lex.opts.IsIdentCont; cont != nil {
+		return !cont(c)
+	}
+	return !isIdentCont(c)
+}
+
+func (lex *Lexer) skipNontokens() {
+	for lex.isNontoken(lex.r) {
+		lex.skipASCIINontokens()
+		lex.next()
+		// Skipped input isn't part of any token: fill needn't keep it, and
+		// it doesn't count towards MaxTokenLen.
+		lex.start = lex.rpos
+	}
+}
+
+// skipASCIINontokens is a fast path for skipNontokens. All non-token runes are
+// ASCII, so a run of them in buf can be skipped byte by byte, without decoding
+// and without next's checks for reading more input. It stops on the last one,
+// for next to move on to whatever follows, which may be multibyte or need more
+// input. The line and column are counted as countRune does.
+func (lex *Lexer) skipASCIINontokens() {
+	buf, i := lex.buf, lex.nextpos
+	r, line, col, lineRunes := lex.r, lex.line, lex.col, lex.lineRunes
+	for ; i < len(buf) && lex.isNontoken(rune(buf[i])); i++ {
+		next := rune(buf[i])
+		switch {
+		case r == ' ':
+			col++
+		case r == '\n' || r == '\r' && next != '\n':
+			line++
+			lineRunes += col
+			col = 1
+		case r == '\t' && lex.opts.TabWidth > 1:
+			tab := nextTabStop(col, lex.opts.TabWidth)
+			lineRunes -= tab - col - 1
+			col = tab
+		default:
+			col++
+		}
+		r = next
+	}
+	if i == lex.nextpos {
+		return
+	}
+	lex.r, lex.rpos, lex.nextpos = r, i-1, i
+	lex.line, lex.col, lex.lineRunes = line, col, lineRunes
+	lex.start = lex.rpos
+}
+
+// isNontoken reports whether r is whitespace that separates tokens.
+func (lex *Lexer) isNontoken(r rune) bool {
+	return r == ' ' || r == '\t' || (r == '\n' || r == '\r') && !lex.opts.SignificantNewlines ||
+		r == 0 && lex.opts.Nul == NulWhitespace
+}
+
+// skipLineBreak moves past the line break at the current rune, which is a
+// '\n', a "\r\n" pair or a lone '\r'.
+func (lex *Lexer) skipLineBreak() {
+	if lex.r == '\r' && lex.peekNextByte() == '\n' {
+		lex.next()
+	}
+	lex.next()
+}
+
+// scanNewline scans a line break into a NEWLINE token, then skips any blank
+// lines following it. With the Indentation option, blank lines are left for
+// scanIndentation instead, and with EmitWhitespace they become WHITESPACE and
+// NEWLINE tokens.
+func (lex *Lexer) scanNewline() Token {
+	lex.skipLineBreak()
+	tok := lex.emit(NEWLINE)
+	if lex.opts.Indentation {
+		lex.atLineStart = true
+		return tok
+	}
+	if lex.opts.EmitWhitespace {
+		return tok
+	}
+	for lex.skipNontokens(); lex.r == '\n' || lex.r == '\r'; lex.skipNontokens() {
+		lex.skipLineBreak()
+		lex.start = lex.rpos
+	}
+	return tok
+}
+
+// scanIndentation implements the Indentation option. It returns any pending
+// DEDENT tokens and, at the start of a line, compares the indentation of the
+// next line that isn't blank or a line comment with the open indentation
+// levels to produce an INDENT, DEDENT or ERROR token. It returns a comment
+// that fills a line as a COMMENT token. It returns false if there's no such
+// token to return.
+func (lex *Lexer) scanIndentation() (Token, bool) {
+	if lex.dedents > 0 {
+		lex.dedents--
+		lex.startToken()
+		return lex.emit(DEDENT), true
+	}
+	if !lex.atLineStart {
+		return Token{}, false
+	}
+	lex.atLineStart = false
+
+	// Measure the indentation of the next non-blank line; the INDENT token's
+	// value is the indentation itself.
+	var width int
+	var tabs, spaces bool
+	for {
+		lex.startToken()
+		width, tabs, spaces = 0, false, false
+		for lex.r == ' ' || lex.r == '\t' {
+			tabs = tabs || lex.r == '\t'
+			spaces = spaces || lex.r == ' '
+			width++
+			lex.next()
+		}
+		if lex.r != '\n' && lex.r != '\r' {
+			break
+		}
+		lex.skipLineBreak()
+	}
+
+	// Like a blank line, a line holding only a comment doesn't count, so
+	// just return the comment and measure the next line.
+	if lex.atLineComment() {
+		tok := lex.scanComment()
+		if lex.r == '\n' || lex.r == '\r' {
+			lex.skipLineBreak()
+			lex.atLineStart = true
+		}
+		return tok, true
+	}
+
+	// Open levels at the end of the input are closed by scanToken.
+	if lex.r < 0 {
+		return Token{}, false
+	}
+	if tabs && spaces {
+		return lex.makeErrorToken("mixed tabs and spaces in indentation"), true
+	}
+
+	level := lex.indentLevel()
+	if width > level {
+		lex.indents = append(lex.indents, width)
+		return lex.emit(INDENT), true
+	} else if width == level {
+		return Token{}, false
+	}
+
+	n := 0
+	for lex.indentLevel() > width {
+		lex.indents = lex.indents[:len(lex.indents)-1]
+		n++
+	}
+	if lex.indentLevel() != width {
+		// Carry on as if width were a new level so later lines still line up.
+		lex.indents = append(lex.indents, width)
+		return lex.makeErrorToken("unindent does not match any outer indentation level"), true
+	}
+	lex.dedents = n - 1
+	lex.startToken()
+	return lex.emit(DEDENT), true
+}
+
+// atLineComment reports whether the current rune starts a line comment.
+func (lex *Lexer) atLineComment() bool {
+	if lex.opts.LineCommentPrefixes == nil {
+		return lex.r == '/' && lex.peekNextByte() == '/'
+	}
+	for _, prefix := range lex.opts.LineCommentPrefixes {
+		if prefix != "" && lex.lookingAt(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// indentLevel returns the width of the innermost open indentation level.
+func (lex *Lexer) indentLevel() int {
+	if n := len(lex.indents); n > 0 {
+		return lex.indents[n-1]
+	}
+	return 0
+}
+
+// scanIdentifier scans an identifier, which may turn out to be a keyword. The
+// current rune has already been found to start one.
+func (lex *Lexer) scanIdentifier() Token {
+	for {
+		lex.next()
+		if cont := lex.opts.IsIdentCont; cont != nil {
+			for cont(lex.r) {
+				lex.next()
+			}
+		} else {
+			for isIdentCont(lex.r) {
+				lex.next()
+			}
+		}
+
+		// With DottedIdentifiers, carry on through a '.' that's followed by
+		// another identifier.
+		if !lex.opts.DottedIdentifiers || lex.r != '.' {
+			break
+		}
+		if !lex.identStart(lex.peekRune()) {
+			break
+		}
+		lex.next()
+	}
+
+	tok := lex.emit(IDENTIFIER)
+	if lex.opts.Keywords != nil {
+		text := toUTF8(lex.buf[lex.start:lex.rpos], lex.opts.Encoding)
+		if lex.opts.CaseInsensitiveKeywords {
+			text = bytes.ToLower(text)
+		}
+		if name, ok := lex.opts.Keywords[string(text)]; ok {
+			tok.Name = name
+		}
+	}
+	if lex.opts.FloatKeywords && tok.Name == IDENTIFIER && floatKeywords[string(lex.buf[lex.start:lex.rpos])] {
+		tok.Name = NUMBER
+	}
+	return tok
+}
+
+// floatKeywords are the identifiers that are NUMBER tokens with FloatKeywords.
+var floatKeywords = map[string]bool{"inf": true, "Inf": true, "nan": true, "NaN": true}
+
+// scanNumber scans a number. This is either an integer with a base prefix
+// ("0x1F", "0o17", "0b1010"), or a decimal number: an optional integer part,
+// optionally followed by a decimal point and an optional fractional part,
+// optionally followed by an exponent ("1e10", "2.5E-3"). Either of the integer
+// and fractional parts may be omitted (as in ".5" or "5."), but not both. The
+// number ends at the first complete float, so "3.14.15" scans as "3.14"
+// followed by ".15". Runs of digits other than the exponent may contain '_'
+// separators. With the UnitSuffixes option, a decimal number may be followed
+// by a unit.
+func (lex *Lexer) scanNumber() Token {
+	if lex.r == '0' {
+		// A base prefix only counts if at least one digit of that base follows
+		// it; otherwise the number is just "0", so "0xG" scans as "0" followed
+		// by the identifier "xG".
+		if isBaseDigit := prefixDigitClass(lex.peekNextByte()); isBaseDigit != nil && isBaseDigit(lex.peekByte(1)) {
+			lex.next()
+			lex.next()
+			lex.scanDigitsOf(isBaseDigit)
+			return lex.emit(NUMBER)
+		}
+	}
+
+	lex.scanDigits()
+	// A '.' followed by another '.' is left alone, since ".." isn't a decimal
+	// point.
+	if lex.r == '.' && lex.peekNextByte() != '.' {
+		lex.next()
+		lex.scanDigits()
+	}
+
+	// An exponent is only consumed if it's complete; in "1e" or "1e+" the
+	// number ends before the 'e'.
+	if lex.r == 'e' || lex.r == 'E' {
+		if isDigit(lex.peekNextByte()) {
+			lex.next()
+			lex.scanExponentDigits()
+		} else if sign := lex.peekNextByte(); (sign == '+' || sign == '-') && isDigit(lex.peekByte(1)) {
+			lex.next()
+			lex.next()
+			lex.scanExponentDigits()
+		}
+	}
+	tok := lex.emit(NUMBER)
+	if lex.opts.UnitSuffixes && unicode.IsLetter(lex.r) {
+		// Relative to the start of the token, which fill keeps in place
+		// when it moves the input around in buf.
+		unit := lex.rpos - lex.start
+		for unicode.IsLetter(lex.r) {
+			lex.next()
+		}
+		tok.Unit = string(toUTF8(lex.buf[lex.start+unit:lex.rpos], lex.opts.Encoding))
+	}
+	return tok
+}
+
+// scanDigits consumes a (possibly empty) run of decimal digits.
+func (lex *Lexer) scanDigits() {
+	lex.scanDigitsOf(isDigit)
+}
+
+// scanExponentDigits consumes the digits of an exponent, which unlike other
+// runs of digits can't contain separators.
+func (lex *Lexer) scanExponentDigits() {
+	for isDigit(lex.r) {
+		lex.next()
+	}
+}
+
+// scanDigitsOf consumes a (possibly empty) run of digits accepted by
+// isDigitOf. Single underscores are accepted between digits as separators, as
+// in "1_000"; a trailing or doubled underscore ends the run before it.
+func (lex *Lexer) scanDigitsOf(isDigitOf func(rune) bool) {
+	for isDigitOf(lex.r) {
+		lex.next()
+		if lex.r == '_' && isDigitOf(lex.peekNextByte()) {
+			lex.next()
+		}
+	}
+}
+
+// scanQuote scans a double-quoted string. Backslash escapes are recognized so
+// that an escaped quote doesn't end the string; the token's value is the raw
+// source text including the quotes. An invalid escape produces an ERROR token
+// at its backslash, after the rest of the string has been skipped.
+func (lex *Lexer) scanQuote() Token {
+	lex.next()
+	var escErr *Token
+	for lex.r >= 0 && lex.r != '"' {
+		if lex.r == '\\' {
+			errTok := lex.makeErrorTokenAtRune("invalid escape sequence")
+			if !lex.scanEscape('"') && escErr == nil {
+				escErr = &errTok
+			}
+			continue
+		}
+		lex.next()
+	}
+
+	if lex.r < 0 {
+		return lex.makeErrorToken("unterminated string literal")
+	} else {
+		lex.next()
+		if escErr != nil {
+			return *escErr
+		}
+		return lex.emit(QUOTE)
+	}
+}
+
+// scanEscape consumes an escape sequence starting at the current '\' inside a
+// literal delimited by quote, and reports whether it was valid. Only the
+// escapes understood by unquote are valid.
+func (lex *Lexer) scanEscape(quote rune) bool {
+	lex.next()
+	switch lex.r {
+	case quote, '\\', 'n', 't', 'r':
+		lex.next()
+		return true
+	case 'u':
+		lex.next()
+		for i := 0; i < 4; i++ {
+			if !isHexDigit(lex.r) {
+				return false
+			}
+			lex.next()
+		}
+		return true
+	}
+	return false
+}
+
+// scanChar scans a single-quoted character literal holding exactly one
+// character or escape sequence, such as 'a', '\n' or '\u00e4'.
+func (lex *Lexer) scanChar() Token {
+	lex.next()
+	switch lex.r {
+	case '\'':
+		lex.next()
+		return lex.makeErrorToken("empty character literal")
+	case '\\':
+		errTok := lex.makeErrorTokenAtRune("invalid escape sequence")
+		if !lex.scanEscape('\'') {
+			return errTok
+		}
+	case '\n', '\r', -1:
+		return lex.makeErrorToken("unterminated character literal")
+	default:
+		lex.next()
+	}
+
+	if lex.r != '\'' {
+		if !lex.closingQuoteOnLine() {
+			return lex.makeErrorToken("unterminated character literal")
+		}
+		// Skip the rest of a literal like 'ab', so that its closing quote
+		// doesn't start another one. The loop also stops where MaxTokenLen
+		// cut the literal short, which NextToken then reports.
+		for lex.r >= 0 && lex.r != '\'' && lex.r != '\n' && lex.r != '\r' {
+			if lex.r == '\\' {
+				lex.next()
+			}
+			lex.next()
+		}
+		if lex.r == '\'' {
+			lex.next()
+		}
+		return lex.makeErrorToken("character literal has more than one character")
+	}
+	lex.next()
+	return lex.emit(CHAR)
+}
+
+// closingQuoteOnLine reports whether a single quote, not escaped by a
+// backslash, follows from the current rune on before the end of the line. So
+// as not to buffer a long line, it gives up and reports true once the literal
+// would exceed MaxTokenLen, leaving scanChar to run into the limit.
+func (lex *Lexer) closingQuoteOnLine() bool {
+	escaped := lex.r == '\\'
+	for n := 0; ; n++ {
+		if max := lex.opts.MaxTokenLen; max > 0 && lex.nextpos+n-lex.start > max {
+			return true
+		}
+		c := lex.peekByte(n)
+		switch {
+		case c < 0 || c == '\n' || c == '\r':
+			return false
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '\'':
+			return true
+		}
+	}
+}
+
+// scanRawQuote scans a backtick-delimited raw string. No escapes are processed
+// and the string may span multiple lines.
+func (lex *Lexer) scanRawQuote() Token {
+	lex.next()
+	for lex.r >= 0 && lex.r != '`' {
+		lex.next()
+	}
+
+	if lex.r < 0 {
+		return lex.makeErrorToken("unterminated raw string literal")
+	}
+	lex.next()
+	return lex.emit(RAW_QUOTE)
+}
+
+// scanBlockQuote scans a block string delimited by three double quotes, as in
+// """a "quoted" word""". Like a raw string it may contain newlines, and
+// backslashes have no special meaning.
+func (lex *Lexer) scanBlockQuote() Token {
+	for i := 0; i < 3; i++ {
+		lex.next()
+	}
+	for lex.r >= 0 && !(lex.r == '"' && lex.lookingAt(`"""`)) {
+		lex.next()
+	}
+
+	if lex.r < 0 {
+		return lex.makeErrorToken("unterminated block string literal")
+	}
+	for i := 0; i < 3; i++ {
+		lex.next()
+	}
+	return lex.emit(BLOCK_QUOTE)
+}
+
+// scanComment scans a "//" comment up to (but not including) the end of the
+// line.
+func (lex *Lexer) scanComment() Token {
+	lex.next()
+	for lex.r >= 0 && lex.r != '\n' && lex.r != '\r' {
+		lex.next()
+	}
+	return lex.emit(COMMENT)
+}
+
+// scanBlockComment scans a "/* ... */" comment, which may span multiple lines.
+// With the NestedComments option, each "/*" inside the comment must be matched
+// by its own "*/". An unterminated comment produces an ERROR token at the
+// opening "/*".
+func (lex *Lexer) scanBlockComment() Token {
+	// Skip over the opening "/*".
+	lex.next()
+	lex.next()
+	depth := 1
+	for lex.r >= 0 {
+		switch {
+		case lex.r == '*' && lex.peekNextByte() == '/':
+			lex.next()
+			lex.next()
+			if depth--; depth == 0 {
+				return lex.emit(COMMENT)
+			}
+		case lex.r == '/' && lex.peekNextByte() == '*' && lex.opts.NestedComments:
+			lex.next()
+			lex.next()
+			depth++
+		default:
+			lex.next()
+		}
+	}
+	if lex.opts.NestedComments {
+		return lex.makeErrorToken(fmt.Sprintf("unterminated block comment (nesting depth %d)", depth))
+	}
+	return lex.makeErrorToken("unterminated block comment")
+}
+
+// unquote decodes a double- or single-quoted literal with the escapes accepted
+// by scanEscape: \", \' (matching the literal's quote), \\, \n, \t, \r and
+// \uXXXX.
+func unquote(s string) (string, error) {
+	n := len(s)
+	if n < 2 || s[0] != '"' && s[0] != '\'' || s[n-1] != s[0] {
+		return "", fmt.Errorf("invalid quoted string %q", s)
+	}
+	quote := s[0]
+	s = s[1 : n-1]
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		if i+1 >= len(s) {
+			return "", fmt.Errorf("invalid escape at end of string")
+		}
+		i++
+		switch s[i] {
+		case quote, '\\':
+			b.WriteByte(s[i])
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case 'u':
+			if i+5 > len(s) {
+				return "", fmt.Errorf("invalid escape \\%s", s[i:])
+			}
+			v, err := strconv.ParseUint(s[i+1:i+5], 16, 32)
+			if err != nil {
+				return "", fmt.Errorf("invalid escape \\%s", s[i:i+5])
+			}
+			b.WriteRune(rune(v))
+			i += 4
+		default:
+			return "", fmt.Errorf("invalid escape \\%c", s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// prefixDigitClass returns the digit predicate for the base introduced by the
+// given prefix rune (the rune after a leading '0'), or nil if r isn't a base
+// prefix.
+func prefixDigitClass(r rune) func(rune) bool {
+	switch r {
+	case 'x', 'X':
+		return isHexDigit
+	case 'o', 'O':
+		return isOctalDigit
+	case 'b', 'B':
+		return isBinaryDigit
+	}
+	return nil
+}
+
+func isAlpha(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || r == '_' || r == '$'
+}
+
+// isIdentStart reports whether r can begin an identifier: an ASCII letter, '_',
+// '$', or any Unicode letter.
+func isIdentStart(r rune) bool {
+	return isAlpha(r) || r >= utf8.RuneSelf && unicode.IsLetter(r)
+}
+
+// isIdentCont reports whether r can continue an identifier: anything that can
+// begin one, a digit, or a combining mark (so that "e\u0301" is a single
+// identifier).
+func isIdentCont(r rune) bool {
+	if r < utf8.RuneSelf {
+		return isAlpha(r) || isDigit(r)
+	}
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
+
+func isDigit(r rune) bool {
 	return '0' <= r && r <= '9'
 }
 
+func isHexDigit(r rune) bool {
+	return isDigit(r) || 'a' <= r && r <= 'f' || 'A' <= r && r <= 'F'
+}
+
+func isOctalDigit(r rune) bool {
+	return '0' <= r && r <= '7'
+}
+
+func isBinaryDigit(r rune) bool {
+	return r == '0' || r == '1'
+}
+
 //------------------------------------------------------------------------------
 
 func main() {